@@ -0,0 +1,361 @@
+// Package bench drives a local cluster of kv replicas with proposals, to
+// measure the throughput and latency against the conflict rate, as in the
+// evaluation of the EPaxos paper.
+//
+// Each client proposes one command at a time to its replica, the clients
+// are spread over the replicas. A conflicting command writes the key
+// shared by all clients, the others write the key of their client, so
+// they never conflict with the commands of other replicas.
+package bench
+
+// @decision(10/18/26):
+// - The clients are closed loop, Rate only paces them: if they can't keep
+// - up, the ticks are dropped and the throughput is below the rate.
+// - The fast path ratio counts instances, not commands, they differ if
+// - batching is enabled.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/replica"
+	"github.com/go-distributed/epaxos/statemachine"
+	"github.com/go-distributed/epaxos/transporter"
+)
+
+// transports of the cluster
+const (
+	TransportDummy = "dummy" // in-process channels
+	TransportUDP   = "udp"   // loopback
+)
+
+// the key written by all conflicting commands
+const conflictKey = "hot"
+
+// the max time to wait for the proposals in flight once the run is over
+const drainTimeout = time.Second * 5
+
+var (
+	ErrInvalidSize         = errors.New("The cluster size should be odd")
+	ErrInvalidConflictRate = errors.New("The conflict rate should be in [0, 100]")
+	ErrInvalidClients      = errors.New("At least one client is needed")
+	ErrInvalidRate         = errors.New("The rate should be in [0, 1e9]")
+	ErrInvalidCommandSize  = errors.New("The command size should be at least 1")
+)
+
+// Config describes a run.
+type Config struct {
+	Size      int    // number of replicas
+	Transport string // dummy by default
+	BasePort  int    // the udp transport listens on 127.0.0.1:BasePort+id
+
+	Duration     time.Duration
+	Clients      int     // concurrent clients
+	Rate         int     // proposals per second of all clients, 0 means no limit
+	ConflictRate float64 // percentage of conflicting commands
+	CommandSize  int     // bytes of the value written by a command
+	Seed         int64
+
+	EnableBatching         bool
+	BatchInterval          time.Duration
+	EnableAdaptiveBatching bool
+	TimeoutInterval        time.Duration
+}
+
+// Latency is the distribution of the latency of the proposals.
+type Latency struct {
+	Mean time.Duration
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+// Result is the outcome of a run.
+type Result struct {
+	Proposals  int // proposals executed
+	Conflicts  int // conflicting proposals executed
+	Errors     int
+	Duration   time.Duration
+	Throughput float64 // proposals per second
+	Latency    Latency
+	FastPath   uint64 // instances committed on the fast path
+	SlowPath   uint64 // instances sent to the slow path
+}
+
+// FastPathRatio returns the share of instances committed on the fast path.
+func (r *Result) FastPathRatio() float64 {
+	if r.FastPath+r.SlowPath == 0 {
+		return 0
+	}
+	return float64(r.FastPath) / float64(r.FastPath+r.SlowPath)
+}
+
+// WriteText writes a human readable report.
+func (r *Result) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "proposals:  %v (%v conflicting, %v errors) in %v\n"+
+		"throughput: %.1f proposals/s\n"+
+		"latency:    mean %v, p50 %v, p90 %v, p99 %v, max %v\n"+
+		"fast path:  %.1f%% (%v fast, %v slow instances)\n",
+		r.Proposals, r.Conflicts, r.Errors, r.Duration,
+		r.Throughput,
+		r.Latency.Mean, r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max,
+		r.FastPathRatio()*100, r.FastPath, r.SlowPath)
+	return err
+}
+
+func (c *Config) validate() error {
+	if c.Size <= 0 || c.Size%2 == 0 || c.Size > 255 {
+		return ErrInvalidSize
+	}
+	if c.ConflictRate < 0 || c.ConflictRate > 100 {
+		return ErrInvalidConflictRate
+	}
+	if c.Clients <= 0 {
+		return ErrInvalidClients
+	}
+	// the ticker period is a second divided by the rate
+	if c.Rate < 0 || c.Rate > int(time.Second) {
+		return ErrInvalidRate
+	}
+	if c.CommandSize < 1 {
+		return ErrInvalidCommandSize
+	}
+	switch c.Transport {
+	case "":
+		c.Transport = TransportDummy
+	case TransportDummy, TransportUDP:
+	default:
+		return fmt.Errorf("unknown transport %q", c.Transport)
+	}
+	return nil
+}
+
+// startCluster starts the replicas, their stores are in dir.
+func startCluster(c *Config, dir string) ([]*replica.Replica, error) {
+	nodes := make([]*replica.Replica, c.Size)
+	addrs := make([]string, c.Size)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("127.0.0.1:%d", c.BasePort+i)
+	}
+
+	for i := range nodes {
+		var tr epaxos.Transporter
+		if c.Transport == TransportUDP {
+			var err error
+			if tr, err = transporter.NewUDPTransporter(addrs, uint8(i), c.Size); err != nil {
+				stopCluster(nodes[:i])
+				return nil, err
+			}
+		} else {
+			tr = transporter.NewDummyTR(uint8(i), c.Size)
+		}
+		r, err := replica.New(&replica.Param{
+			ReplicaId:              uint8(i),
+			Size:                   uint8(c.Size),
+			Addrs:                  addrs,
+			StateMachine:           statemachine.NewKV(),
+			Transporter:            tr,
+			TimeoutInterval:        c.TimeoutInterval,
+			EnableBatching:         c.EnableBatching,
+			BatchInterval:          c.BatchInterval,
+			EnableAdaptiveBatching: c.EnableAdaptiveBatching,
+			PersistentPath:         filepath.Join(dir, fmt.Sprint(i)),
+		})
+		if err != nil {
+			stopCluster(nodes[:i])
+			return nil, err
+		}
+		nodes[i] = r
+	}
+
+	if c.Transport == TransportDummy {
+		chs := make([]chan message.Message, c.Size)
+		for i, r := range nodes {
+			chs[i] = r.MessageChan
+		}
+		for _, r := range nodes {
+			r.Transporter.(*transporter.DummyTransporter).RegisterChannels(chs)
+		}
+	}
+	for i, r := range nodes {
+		if err := r.Start(); err != nil {
+			stopCluster(nodes[:i])
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+func stopCluster(nodes []*replica.Replica) {
+	for _, r := range nodes {
+		r.Stop()
+	}
+}
+
+// clientResult is what a client measured.
+type clientResult struct {
+	latencies []time.Duration
+	conflicts int
+	errors    int
+}
+
+// runClient proposes commands to r until done is closed.
+func runClient(id int, r *replica.Replica, c *Config, ticks <-chan time.Time,
+	done <-chan struct{}, res *clientResult) {
+
+	rnd := rand.New(rand.NewSource(c.Seed + int64(id)))
+	key := fmt.Sprintf("client-%d", id)
+	value := strings.Repeat("x", c.CommandSize)
+
+	for {
+		if ticks != nil {
+			select {
+			case <-ticks:
+			case <-done:
+				return
+			}
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		conflict := rnd.Float64()*100 < c.ConflictRate
+		cmd := message.Command("put " + key + " " + value)
+		if conflict {
+			cmd = message.Command("put " + conflictKey + " " + value)
+		}
+
+		start := time.Now()
+		results, err := r.ProposeAndWait(cmd)
+		if err == nil && len(results) == 1 {
+			err, _ = results[0].(error)
+		}
+		if err != nil {
+			res.errors++
+			continue
+		}
+		res.latencies = append(res.latencies, time.Since(start))
+		if conflict {
+			res.conflicts++
+		}
+	}
+}
+
+// Run starts a cluster, drives it for c.Duration, and stops it.
+func Run(c *Config) (*Result, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "epaxos-bench-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	nodes, err := startCluster(c, dir)
+	if err != nil {
+		return nil, err
+	}
+	stopped := false
+	defer func() {
+		if !stopped {
+			stopCluster(nodes)
+		}
+	}()
+
+	var ticks <-chan time.Time
+	if c.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(c.Rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	done := make(chan struct{})
+	results := make([]clientResult, c.Clients)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			runClient(i, nodes[i%len(nodes)], c, ticks, done, &results[i])
+		}(i)
+	}
+
+	time.Sleep(c.Duration)
+	close(done)
+	elapsed := time.Since(start)
+
+	// wait for the proposals in flight, or give up by stopping the cluster
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(drainTimeout):
+		stopCluster(nodes)
+		stopped = true
+		<-drained
+	}
+
+	res := &Result{Duration: elapsed}
+	var latencies []time.Duration
+	for _, cr := range results {
+		latencies = append(latencies, cr.latencies...)
+		res.Conflicts += cr.conflicts
+		res.Errors += cr.errors
+	}
+	res.Proposals = len(latencies)
+	res.Throughput = float64(res.Proposals) / elapsed.Seconds()
+	res.Latency = latencyOf(latencies)
+	for _, r := range nodes {
+		fast, slow := r.PathCounts()
+		res.FastPath += fast
+		res.SlowPath += slow
+	}
+	return res, nil
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+
+// latencyOf returns the distribution of the latencies, which are sorted.
+func latencyOf(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sort.Sort(durations(latencies))
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	percentile := func(p int) time.Duration {
+		return latencies[(len(latencies)-1)*p/100]
+	}
+	return Latency{
+		Mean: sum / time.Duration(len(latencies)),
+		P50:  percentile(50),
+		P90:  percentile(90),
+		P99:  percentile(99),
+		Max:  latencies[len(latencies)-1],
+	}
+}
@@ -0,0 +1,92 @@
+package bench
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func benchTestlibConfig() *Config {
+	return &Config{
+		Size:            3,
+		Duration:        time.Millisecond * 300,
+		Clients:         3,
+		CommandSize:     16,
+		TimeoutInterval: time.Second,
+	}
+}
+
+// Without conflicts, every instance should take the fast path.
+func TestRunNoConflict(t *testing.T) {
+	res, err := Run(benchTestlibConfig())
+	assert.NoError(t, err)
+	assert.True(t, res.Proposals > 0)
+	assert.Equal(t, res.Conflicts, 0)
+	assert.Equal(t, res.Errors, 0)
+	assert.Equal(t, res.SlowPath, uint64(0))
+	assert.Equal(t, res.FastPath, uint64(res.Proposals))
+	assert.Equal(t, res.FastPathRatio(), 1.0)
+	assert.True(t, res.Latency.P50 <= res.Latency.P99)
+	assert.True(t, res.Latency.P99 <= res.Latency.Max)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, res.WriteText(buf))
+	assert.True(t, strings.Contains(buf.String(), "fast path:  100.0%"))
+}
+
+func TestRunConflicts(t *testing.T) {
+	c := benchTestlibConfig()
+	c.ConflictRate = 100
+	c.Rate = 200
+	res, err := Run(c)
+	assert.NoError(t, err)
+	assert.True(t, res.Proposals > 0)
+	assert.Equal(t, res.Conflicts, res.Proposals)
+	assert.True(t, res.Throughput <= 200*1.5)
+}
+
+func TestInvalidConfig(t *testing.T) {
+	c := benchTestlibConfig()
+	c.Size = 4
+	_, err := Run(c)
+	assert.Equal(t, err, ErrInvalidSize)
+
+	c = benchTestlibConfig()
+	c.ConflictRate = 101
+	_, err = Run(c)
+	assert.Equal(t, err, ErrInvalidConflictRate)
+
+	c = benchTestlibConfig()
+	c.Rate = 2e9
+	_, err = Run(c)
+	assert.Equal(t, err, ErrInvalidRate)
+
+	c = benchTestlibConfig()
+	c.CommandSize = 0
+	_, err = Run(c)
+	assert.Equal(t, err, ErrInvalidCommandSize)
+
+	c = benchTestlibConfig()
+	c.Transport = "tcp"
+	_, err = Run(c)
+	assert.Error(t, err)
+}
+
+func TestLatencyOf(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	l := latencyOf(latencies)
+	assert.Equal(t, l, Latency{
+		Mean: time.Microsecond * 50500,
+		P50:  time.Millisecond * 50,
+		P90:  time.Millisecond * 90,
+		P99:  time.Millisecond * 99,
+		Max:  time.Millisecond * 100,
+	})
+	assert.Equal(t, latencyOf(nil), Latency{})
+}
@@ -0,0 +1,233 @@
+// Package client talks to the replicas of a cluster over their http
+// endpoint: it proposes commands through the client api, and queries and
+// operates the replicas through the admin api.
+package client
+
+// @decision(10/18/26):
+// - A request is sent to the next replica only if the current one can't
+// - be connected, once a proposal is sent it may be executed, so it's
+// - never retried elsewhere.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-distributed/epaxos/replica"
+)
+
+var (
+	ErrNoAddr = errors.New("No replica address")
+)
+
+const defaultTimeout = time.Second * 10
+
+// Error is an error reply of a replica.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", http.StatusText(e.StatusCode), e.Message)
+}
+
+// Result is the result of a command, Value is nil if it
+// failed or returned nothing. Numbers are json.Number.
+type Result struct {
+	Value interface{}
+	Err   error
+}
+
+// Client sends requests to the http endpoints of replicas,
+// it's safe for concurrent use.
+type Client struct {
+	addrs []string
+	http  *http.Client
+
+	lock    sync.Mutex
+	current int // the replica tried first
+}
+
+// New returns a client of the replicas at the http addresses.
+func New(addrs ...string) (*Client, error) {
+	if len(addrs) == 0 {
+		return nil, ErrNoAddr
+	}
+	return &Client{
+		addrs: addrs,
+		http:  &http.Client{Timeout: defaultTimeout},
+	}, nil
+}
+
+// SetTimeout sets the timeout of each request, 0 means no timeout.
+func (c *Client) SetTimeout(d time.Duration) {
+	c.http.Timeout = d
+}
+
+// isDialError returns true if the request wasn't sent.
+func isDialError(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	operr, ok := err.(*net.OpError)
+	return ok && operr.Op == "dial"
+}
+
+// do sends the request to the current replica, or the next ones if it
+// can't be connected, and decodes the json reply into v if it's not nil.
+func (c *Client) do(method, path string, body []byte, v interface{}) error {
+	resp, err := c.send(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		return nil
+	}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// send returns the response if its status is 2xx, an *Error otherwise.
+func (c *Client) send(method, path string, body []byte) (*http.Response, error) {
+	c.lock.Lock()
+	first := c.current
+	c.lock.Unlock()
+
+	var err error
+	for k := range c.addrs {
+		i := (first + k) % len(c.addrs)
+		var req *http.Request
+		req, err = http.NewRequest(method, "http://"+c.addrs[i]+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		var resp *http.Response
+		resp, err = c.http.Do(req)
+		if err != nil {
+			if isDialError(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		c.lock.Lock()
+		c.current = i
+		c.lock.Unlock()
+		if resp.StatusCode/100 != 2 {
+			msg, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+		}
+		return resp, nil
+	}
+	return nil, err
+}
+
+// Propose proposes the commands and returns their results
+// once they are executed.
+func (c *Client) Propose(cmds ...string) ([]Result, error) {
+	body, err := json.Marshal(&replica.ClientRequest{Commands: cmds})
+	if err != nil {
+		return nil, err
+	}
+	var resp replica.ClientResponse
+	if err := c.do("POST", "/client/propose", body, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Results) != len(cmds) {
+		return nil, fmt.Errorf("got %v results for %v commands", len(resp.Results), len(cmds))
+	}
+	results := make([]Result, len(resp.Results))
+	for i, res := range resp.Results {
+		if res.Error != "" {
+			results[i].Err = errors.New(res.Error)
+		} else {
+			results[i].Value = res.Value
+		}
+	}
+	return results, nil
+}
+
+// propose1 proposes a single command.
+func (c *Client) propose1(cmd string) (interface{}, error) {
+	results, err := c.Propose(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return results[0].Value, results[0].Err
+}
+
+// Get reads a key of the kv state machine.
+func (c *Client) Get(key string) (string, bool, error) {
+	v, err := c.propose1("get " + key)
+	if err != nil || v == nil {
+		return "", false, err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", false, fmt.Errorf("unexpected value %v, is the state machine kv?", v)
+	}
+	return s, true, nil
+}
+
+// Put sets a key of the kv state machine.
+func (c *Client) Put(key, value string) error {
+	_, err := c.propose1("put " + key + " " + value)
+	return err
+}
+
+// Delete removes a key of the kv state machine.
+func (c *Client) Delete(key string) error {
+	_, err := c.propose1("delete " + key)
+	return err
+}
+
+// Status returns the state of the replica.
+func (c *Client) Status() (*replica.ReplicaStatus, error) {
+	s := new(replica.ReplicaStatus)
+	if err := c.do("GET", "/admin/replica", nil, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Instance returns the details of an instance.
+func (c *Client) Instance(row uint8, id uint64) (*replica.InstanceStatus, error) {
+	s := new(replica.InstanceStatus)
+	if err := c.do("GET", fmt.Sprintf("/admin/instances/%d/%d", row, id), nil, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Recover starts the recovery of an instance.
+func (c *Client) Recover(row uint8, id uint64) error {
+	return c.do("POST", fmt.Sprintf("/admin/recover/%d/%d", row, id), nil, nil)
+}
+
+// Snapshot writes a snapshot of the state machine to w.
+func (c *Client) Snapshot(w io.Writer) error {
+	resp, err := c.send("POST", "/admin/snapshot", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
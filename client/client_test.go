@@ -0,0 +1,115 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/replica"
+	"github.com/go-distributed/epaxos/statemachine"
+	"github.com/go-distributed/epaxos/transporter"
+	"github.com/stretchr/testify/assert"
+)
+
+// clientTestlibCluster starts a cluster of kv replicas,
+// and a http server for each of them.
+func clientTestlibCluster(size int) ([]*replica.Replica, []*httptest.Server) {
+	nodes := make([]*replica.Replica, size)
+	servers := make([]*httptest.Server, size)
+	chs := make([]chan message.Message, size)
+	for i := range nodes {
+		r, err := replica.New(&replica.Param{
+			ReplicaId:       uint8(i),
+			Size:            uint8(size),
+			TimeoutInterval: time.Second * 50, // disable timeout
+			StateMachine:    statemachine.NewKV(),
+			Transporter:     transporter.NewDummyTR(uint8(i), size),
+			EnableAdmin:     true,
+			EnableClientAPI: true,
+		})
+		if err != nil {
+			panic(err)
+		}
+		nodes[i] = r
+		servers[i] = httptest.NewServer(r.Handler())
+		chs[i] = r.MessageChan
+	}
+	for _, r := range nodes {
+		r.Transporter.(*transporter.DummyTransporter).RegisterChannels(chs)
+		r.Start()
+	}
+	return nodes, servers
+}
+
+func clientTestlibStop(nodes []*replica.Replica, servers []*httptest.Server) {
+	for i := range nodes {
+		servers[i].Close()
+		nodes[i].Stop()
+	}
+}
+
+func clientTestlibAddr(s *httptest.Server) string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+func TestKV(t *testing.T) {
+	nodes, servers := clientTestlibCluster(3)
+	defer clientTestlibStop(nodes, servers)
+
+	// the first address is down
+	c, err := New("127.0.0.1:1", clientTestlibAddr(servers[0]))
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Put("a", "hello world"))
+	v, ok, err := c.Get("a")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, v, "hello world")
+
+	assert.NoError(t, c.Delete("a"))
+	_, ok, err = c.Get("a")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	results, err := c.Propose("put b 1", "bad")
+	assert.NoError(t, err)
+	assert.Nil(t, results[0].Err)
+	assert.Error(t, results[1].Err)
+
+	_, err = New()
+	assert.Equal(t, err, ErrNoAddr)
+}
+
+func TestAdmin(t *testing.T) {
+	nodes, servers := clientTestlibCluster(3)
+	defer clientTestlibStop(nodes, servers)
+
+	c, err := New(clientTestlibAddr(servers[1]))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Put("a", "1"))
+
+	s, err := c.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, s.Replica.Id, uint8(1))
+
+	inst, err := c.Instance(1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, inst.Cmds, []string{"put a 1"})
+
+	_, err = c.Instance(1, 100)
+	assert.Equal(t, err.(*Error).StatusCode, 404)
+
+	assert.NoError(t, c.Recover(1, 1))
+	assert.Equal(t, c.Recover(9, 1).(*Error).StatusCode, 400)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, c.Snapshot(buf))
+	var data map[string]string
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &data))
+	assert.Equal(t, data, map[string]string{"a": "1"})
+
+}
@@ -0,0 +1,87 @@
+// epaxos-bench runs a local cluster of kv replicas, in-process or over
+// loopback udp, drives it with proposals at a target rate and conflict
+// rate, and reports the throughput, the latency percentiles and the fast
+// path ratio.
+//
+//	epaxos-bench -size 5 -clients 50 -conflict 10 -duration 30s
+//	epaxos-bench -transport udp -rate 2000 -conflict 100 -batching
+//	epaxos-bench -conflict 0,25,50,100 -json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-distributed/epaxos/bench"
+)
+
+// run is a line of the json output.
+type run struct {
+	Config        bench.Config
+	Result        *bench.Result
+	FastPathRatio float64
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func main() {
+	var conflicts string
+	var jsonOutput bool
+	c := new(bench.Config)
+
+	flag.IntVar(&c.Size, "size", 3, "number of replicas")
+	flag.StringVar(&c.Transport, "transport", bench.TransportDummy, "transport of the cluster, dummy or udp")
+	flag.IntVar(&c.BasePort, "port", 9400, "first port of the udp transport")
+	flag.DurationVar(&c.Duration, "duration", 10*time.Second, "duration of each run")
+	flag.IntVar(&c.Clients, "clients", 10, "concurrent clients, spread over the replicas")
+	flag.IntVar(&c.Rate, "rate", 0, "proposals per second of all clients, 0 means no limit")
+	flag.StringVar(&conflicts, "conflict", "0", "percentages of conflicting commands, separated by commas, one run each")
+	flag.IntVar(&c.CommandSize, "cmd-size", 16, "bytes of the value written by each command")
+	flag.Int64Var(&c.Seed, "seed", 1, "seed of the conflict choices")
+	flag.BoolVar(&c.EnableBatching, "batching", false, "batch the proposals")
+	flag.DurationVar(&c.BatchInterval, "batch-interval", 0, "interval of the batches, the replica default if 0")
+	flag.BoolVar(&c.EnableAdaptiveBatching, "adaptive", false, "batch the proposals adaptively")
+	flag.DurationVar(&c.TimeoutInterval, "timeout", time.Second, "timeout interval of the replicas")
+	flag.BoolVar(&jsonOutput, "json", false, "print each run as a json line")
+	flag.Parse()
+
+	var rates []float64
+	for _, s := range strings.Split(conflicts, ",") {
+		rate, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			fatal(fmt.Errorf("invalid conflict rate %q", s))
+		}
+		rates = append(rates, rate)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for k, rate := range rates {
+		c.ConflictRate = rate
+		res, err := bench.Run(c)
+		if err != nil {
+			fatal(err)
+		}
+		if jsonOutput {
+			if err := enc.Encode(&run{*c, res, res.FastPathRatio()}); err != nil {
+				fatal(err)
+			}
+			continue
+		}
+		if k > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("== %v replicas, %s, %v clients, %v%% conflicts ==\n",
+			c.Size, c.Transport, c.Clients, rate)
+		if err := res.WriteText(os.Stdout); err != nil {
+			fatal(err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// epaxos-depgraph prints the dependency graph of the instances not executed
+// yet by a replica, to find out why its execution is stuck. The graph is
+// fetched from the admin api of the replica, or read from a json file saved
+// from /admin/graph, and printed as graphviz dot or json.
+//
+//	epaxos-depgraph -addr localhost:8000 | dot -Tsvg > graph.svg
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/go-distributed/epaxos/replica"
+)
+
+func main() {
+	var addr, in, format string
+	var blocking bool
+
+	flag.StringVar(&addr, "addr", "", "http address of the replica, with the admin api enabled")
+	flag.StringVar(&in, "in", "", "json file saved from /admin/graph, instead of -addr")
+	flag.StringVar(&format, "format", "dot", "output format, dot or json")
+	flag.BoolVar(&blocking, "blocking", false, "only list the instances blocking the execution")
+	flag.Parse()
+
+	if (addr == "") == (in == "") {
+		fmt.Fprintln(os.Stderr, "one of -addr and -in is required!")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	g, err := load(addr, in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if blocking {
+		for _, n := range g.Blocking() {
+			fmt.Printf("[%v][%v] %s\n", n.RowId, n.Id, n.Status)
+		}
+		return
+	}
+
+	switch format {
+	case "dot":
+		err = g.WriteDOT(os.Stdout)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(g)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func load(addr, in string) (*replica.DependencyGraph, error) {
+	var r io.Reader
+	if in != "" {
+		f, err := os.Open(in)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	} else {
+		resp, err := http.Get("http://" + addr + "/admin/graph")
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET /admin/graph: %s", resp.Status)
+		}
+		r = resp.Body
+	}
+
+	g := new(replica.DependencyGraph)
+	if err := json.NewDecoder(r).Decode(g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
@@ -0,0 +1,198 @@
+// epaxos-inspect reads the store of a stopped replica, opened read-only.
+//
+//	epaxos-inspect -dir /dev/shm/test-0 replica
+//	epaxos-inspect -dir /dev/shm/test-0 list [-row 1] [-status committed]
+//	epaxos-inspect -dir /dev/shm/test-0 instance <row> <id>
+//	epaxos-inspect -dir /dev/shm/test-0 export > instances.jsonl
+//
+// The replica id is found from the replica record if -id is not given.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/persistent"
+	"github.com/go-distributed/epaxos/replica"
+)
+
+// the max replica id tried when looking for the replica record
+const maxReplicaId = 255
+
+// errUsage is returned by run for an unknown command.
+var errUsage = errors.New("usage")
+
+// exportRecord is a line of the export command.
+type exportRecord struct {
+	Kind     string                  // "replica" or "instance"
+	Replica  *replica.PackedReplica  `json:",omitempty"`
+	Instance *replica.InstanceStatus `json:",omitempty"`
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: epaxos-inspect -dir <dir> [-id <id>] replica|list|instance|export")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func main() {
+	var dir string
+	var id int
+
+	flag.StringVar(&dir, "dir", "", "persistent directory of the replica")
+	flag.IntVar(&id, "id", -1, "id of the replica, found from the store by default")
+	flag.Parse()
+
+	if dir == "" || flag.NArg() == 0 {
+		usage()
+	}
+
+	// exiting skips the deferred calls, so it's only done here,
+	// once run has closed the store and removed its copy
+	err := run(dir, id, flag.Args())
+	if err == errUsage {
+		usage()
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func run(dir string, id int, args []string) error {
+	store, err := persistent.OpenReadOnly(dir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	p, err := loadReplica(store, id)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "replica":
+		return printJSON(p)
+	case "list":
+		return list(store, p, args[1:])
+	case "instance":
+		return instance(store, p, args[1:])
+	case "export":
+		return export(store, p)
+	}
+	return errUsage
+}
+
+func loadReplica(store epaxos.Persistent, id int) (*replica.PackedReplica, error) {
+	if id >= 0 {
+		return replica.LoadReplica(store, uint8(id))
+	}
+	for id := 0; id <= maxReplicaId; id++ {
+		p, err := replica.LoadReplica(store, uint8(id))
+		if err == nil {
+			return p, nil
+		}
+		if err != epaxos.ErrorNotFound {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("no replica record found, use -id")
+}
+
+// forEachInstance calls f with the stored instances of the row,
+// or of all rows if row < 0.
+func forEachInstance(store epaxos.Persistent, p *replica.PackedReplica, row int,
+	f func(s *replica.InstanceStatus) error) error {
+
+	for r := uint8(0); r < p.Size; r++ {
+		if row >= 0 && int(r) != row {
+			continue
+		}
+		for j := uint64(1); j <= p.MaxInstanceNum[r]; j++ {
+			inst, err := replica.LoadInstance(store, p.Id, r, j)
+			if err == epaxos.ErrorNotFound {
+				continue // a checkpoint, or not stored yet
+			}
+			if err != nil {
+				return fmt.Errorf("instance [%v][%v]: %v", r, j, err)
+			}
+			if err := f(replica.NewInstanceStatus(inst)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func list(store epaxos.Persistent, p *replica.PackedReplica, args []string) error {
+	var row int
+	var status string
+
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.IntVar(&row, "row", -1, "only list the instances of the row")
+	fs.StringVar(&status, "status", "", "only list the instances at the status, e.g. committed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	return forEachInstance(store, p, row, func(s *replica.InstanceStatus) error {
+		if status != "" && !strings.EqualFold(s.Status, status) {
+			return nil
+		}
+		executed := ""
+		if s.Executed {
+			executed = " executed"
+		}
+		fmt.Printf("[%v][%v] %s ballot=%s deps=%v cmds=%v%s\n",
+			s.RowId, s.Id, s.Status, s.Ballot, s.Deps, len(s.Cmds), executed)
+		return nil
+	})
+}
+
+func instance(store epaxos.Persistent, p *replica.PackedReplica, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: instance <row> <id>")
+	}
+	row, err := strconv.ParseUint(args[0], 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid row %q", args[0])
+	}
+	id, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid instance id %q", args[1])
+	}
+
+	inst, err := replica.LoadInstance(store, p.Id, uint8(row), id)
+	if err != nil {
+		return err
+	}
+	return printJSON(replica.NewInstanceStatus(inst))
+}
+
+// export writes the replica, then all instances, as json lines.
+func export(store epaxos.Persistent, p *replica.PackedReplica) error {
+	enc := json.NewEncoder(os.Stdout)
+	if err := enc.Encode(&exportRecord{Kind: "replica", Replica: p}); err != nil {
+		return err
+	}
+	return forEachInstance(store, p, -1, func(s *replica.InstanceStatus) error {
+		return enc.Encode(&exportRecord{Kind: "instance", Instance: s})
+	})
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,182 @@
+// epaxosctl sends commands to the replicas of a cluster through their http
+// endpoint, which needs the client api and the admin api enabled.
+//
+//	epaxosctl -addr localhost:8000,localhost:8001 propose "put a 1" "get a"
+//	epaxosctl -addr localhost:8000 get <key>
+//	epaxosctl -addr localhost:8000 put <key> <value>
+//	epaxosctl -addr localhost:8000 delete <key>
+//	epaxosctl -addr localhost:8000 status
+//	epaxosctl -addr localhost:8000 instance <row> <id>
+//	epaxosctl -addr localhost:8000 recover <row> <id>
+//	epaxosctl -addr localhost:8000 snapshot > snapshot.json
+//
+// The next address is tried if a replica can't be connected. get exits
+// with 1 if the key is not set, so it can be used in scripts.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-distributed/epaxos/client"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: epaxosctl -addr <addr>[,<addr>...] "+
+		"propose|get|put|delete|status|instance|recover|snapshot [args]")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// nargs exits if the command doesn't have n arguments.
+func nargs(args []string, n int, usage string) {
+	if len(args) != n {
+		fmt.Fprintln(os.Stderr, "usage: epaxosctl "+usage)
+		os.Exit(2)
+	}
+}
+
+func main() {
+	var addrs string
+	var timeout time.Duration
+
+	flag.StringVar(&addrs, "addr", "", "http addresses of the replicas, separated by commas")
+	flag.DurationVar(&timeout, "timeout", 10*time.Second, "timeout of each request, 0 means no timeout")
+	flag.Parse()
+
+	if addrs == "" || flag.NArg() == 0 {
+		usage()
+	}
+
+	c, err := client.New(strings.Split(addrs, ",")...)
+	if err != nil {
+		fatal(err)
+	}
+	c.SetTimeout(timeout)
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "propose":
+		if len(args) == 0 {
+			fmt.Fprintln(os.Stderr, "usage: epaxosctl propose <command>...")
+			os.Exit(2)
+		}
+		err = propose(c, args)
+	case "get":
+		nargs(args, 1, "get <key>")
+		err = get(c, args[0])
+	case "put":
+		nargs(args, 2, "put <key> <value>")
+		err = c.Put(args[0], args[1])
+	case "delete":
+		nargs(args, 1, "delete <key>")
+		err = c.Delete(args[0])
+	case "status":
+		nargs(args, 0, "status")
+		err = status(c)
+	case "instance":
+		nargs(args, 2, "instance <row> <id>")
+		err = instance(c, args)
+	case "recover":
+		nargs(args, 2, "recover <row> <id>")
+		var row uint8
+		var id uint64
+		if row, id, err = parseInstance(args); err == nil {
+			err = c.Recover(row, id)
+		}
+	case "snapshot":
+		nargs(args, 0, "snapshot")
+		err = c.Snapshot(os.Stdout)
+	default:
+		usage()
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// propose prints the result of each command on a line,
+// and exits with 1 if some of them failed.
+func propose(c *client.Client, cmds []string) error {
+	results, err := c.Propose(cmds...)
+	if err != nil {
+		return err
+	}
+	failed := false
+	for _, res := range results {
+		switch {
+		case res.Err != nil:
+			fmt.Println("error:", res.Err)
+			failed = true
+		case res.Value == nil:
+			fmt.Println("ok")
+		default:
+			fmt.Println(res.Value)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func get(c *client.Client, key string) error {
+	v, ok, err := c.Get(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s is not set\n", key)
+		os.Exit(1)
+	}
+	fmt.Println(v)
+	return nil
+}
+
+func status(c *client.Client) error {
+	s, err := c.Status()
+	if err != nil {
+		return err
+	}
+	return printJSON(s)
+}
+
+func parseInstance(args []string) (uint8, uint64, error) {
+	row, err := strconv.ParseUint(args[0], 10, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid row %q", args[0])
+	}
+	id, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid instance id %q", args[1])
+	}
+	return uint8(row), id, nil
+}
+
+func instance(c *client.Client, args []string) error {
+	row, id, err := parseInstance(args)
+	if err != nil {
+		return err
+	}
+	s, err := c.Instance(row, id)
+	if err != nil {
+		return err
+	}
+	return printJSON(s)
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
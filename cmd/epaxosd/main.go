@@ -0,0 +1,91 @@
+// epaxosd runs a replica of the cluster described by a json config file,
+// see config/example.json. The state machine is picked by the
+// state_machine field of the config, from kv, counter and noop. The client
+// and admin apis are served on the http address of the replica if they're
+// enabled in the config.
+//
+//	epaxosd -config cluster.json -id 0
+//	epaxosd -config cluster.json -id 0 -fresh
+//
+// If persistence is enabled and the store of the replica exists, the
+// replica is restored from it, unless -fresh is given, which deletes it.
+//
+// On SIGINT or SIGTERM, the replica stops serving http, waits for the
+// client requests in flight, and closes its store before exiting.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-distributed/epaxos/config"
+	"github.com/go-distributed/epaxos/logger"
+	"github.com/go-distributed/epaxos/replica"
+)
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func main() {
+	var path string
+	var id int
+	var fresh bool
+
+	flag.StringVar(&path, "config", "", "json config file of the cluster")
+	flag.IntVar(&id, "id", -1, "id of the replica in the config")
+	flag.BoolVar(&fresh, "fresh", false, "start from an empty store, deleting the existing one")
+	flag.Parse()
+
+	if path == "" || id < 0 || id > 255 {
+		fmt.Fprintln(os.Stderr, "-config and -id are required!")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	c, err := config.Load(path)
+	if err != nil {
+		fatal(err)
+	}
+	restore := false
+	if c.Persistence.Enabled && !fresh {
+		if _, err := os.Stat(c.PersistentPath(uint8(id))); err == nil {
+			restore = true
+		} else if !os.IsNotExist(err) {
+			fatal(err)
+		}
+	}
+	param, err := c.Param(uint8(id), restore)
+	if err != nil {
+		fatal(err)
+	}
+	log := logger.NewGlog(nil)
+	param.Logger = log
+
+	r, err := replica.New(param)
+	if err != nil {
+		fatal(err)
+	}
+	if err := r.Start(); err != nil {
+		fatal(err)
+	}
+	log.Info(logger.Dispatch, 0, "replica started", logger.Fields{
+		"replica":       id,
+		"addr":          c.Replicas[id].Addr,
+		"http_addr":     c.Replicas[id].HTTPAddr,
+		"state_machine": c.StateMachine,
+	})
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigs
+	log.Info(logger.Dispatch, 0, "stopping replica", logger.Fields{"replica": id, "signal": sig.String()})
+	r.Stop()
+	if err := r.Err(); err != nil {
+		fatal(err)
+	}
+}
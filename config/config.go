@@ -0,0 +1,318 @@
+package config
+
+// This file implements the json config of a cluster, shared by all its
+// replicas, see example.json. A replica server loads it, and picks its own
+// replica by id. The state machine is picked by name from the registry of
+// the statemachine package.
+// @decision(10/18/26):
+// - Replica ids are their indexes in the replicas list, since the replica
+// - uses them as indexes of its instance spaces.
+// - Only the udp transport and the gob codec exist now, they are named in
+// - the config so others can be added without breaking config files.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/replica"
+	"github.com/go-distributed/epaxos/statemachine"
+	"github.com/go-distributed/epaxos/transporter"
+)
+
+// supported transports and codecs, and the default state machine
+const (
+	TransportUDP        = "udp"
+	CodecGob            = "gob"
+	DefaultStateMachine = "kv"
+)
+
+// Duration is a time.Duration written as a string in json, e.g. "50ms".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string, e.g. \"50ms\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Replica is a member of the cluster.
+type Replica struct {
+	Id       uint8  `json:"id"`
+	Addr     string `json:"addr"`                // address of the transport
+	HTTPAddr string `json:"http_addr,omitempty"` // metrics and admin api, optional
+}
+
+type Persistence struct {
+	Enabled bool `json:"enabled"`
+	// Dir contains the store of each replica, in replica-<id>.
+	Dir string `json:"dir,omitempty"`
+}
+
+// Timeouts are the intervals of the replica, 0 means the default.
+type Timeouts struct {
+	Timeout Duration `json:"timeout,omitempty"`
+	Digest  Duration `json:"digest,omitempty"`
+}
+
+// Batching of proposals, 0 means the default.
+type Batching struct {
+	Enabled        bool     `json:"enabled"`
+	Interval       Duration `json:"interval,omitempty"`
+	Adaptive       bool     `json:"adaptive,omitempty"`
+	MaxCommands    int      `json:"max_commands,omitempty"`
+	MaxBytes       int      `json:"max_bytes,omitempty"`
+	MaxOutstanding int      `json:"max_outstanding,omitempty"`
+	ProposeWindow  int      `json:"propose_window,omitempty"`
+}
+
+// Features are the optional behaviours of the replica.
+type Features struct {
+	Forwarding bool `json:"forwarding,omitempty"`
+	Digest     bool `json:"digest,omitempty"`
+	Tracing    bool `json:"tracing,omitempty"`
+	Admin      bool `json:"admin,omitempty"`
+	Client     bool `json:"client,omitempty"`
+}
+
+// Config describes a cluster.
+type Config struct {
+	Replicas        []Replica   `json:"replicas"`
+	Transport       string      `json:"transport,omitempty"`     // udp by default
+	Codec           string      `json:"codec,omitempty"`         // gob by default
+	StateMachine    string      `json:"state_machine,omitempty"` // kv by default
+	Persistence     Persistence `json:"persistence"`
+	Timeouts        Timeouts    `json:"timeouts"`
+	Batching        Batching    `json:"batching"`
+	CheckpointCycle uint64      `json:"checkpoint_cycle,omitempty"`
+	Features        Features    `json:"features"`
+}
+
+// ValidationError lists all the problems of a config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+func (e *ValidationError) add(format string, a ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, a...))
+}
+
+// Load reads and validates the config file.
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Parse decodes and validates a json config, unknown fields are errors.
+func Parse(b []byte) (*Config, error) {
+	c := new(Config)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate returns a *ValidationError if the config is not valid,
+// and sets the default transport, codec and state machine.
+func (c *Config) Validate() error {
+	e := new(ValidationError)
+
+	if c.Transport == "" {
+		c.Transport = TransportUDP
+	}
+	if c.Codec == "" {
+		c.Codec = CodecGob
+	}
+	if c.StateMachine == "" {
+		c.StateMachine = DefaultStateMachine
+	}
+
+	switch {
+	case len(c.Replicas) == 0:
+		e.add("replicas: no replica")
+	case len(c.Replicas)%2 == 0:
+		e.add("replicas: the number of replicas should be odd, got %v", len(c.Replicas))
+	case len(c.Replicas) > 255:
+		e.add("replicas: at most 255 replicas, got %v", len(c.Replicas))
+	}
+
+	addrs := make(map[string]int)
+	for k, r := range c.Replicas {
+		if int(r.Id) != k {
+			e.add("replicas[%v].id: should be %v, ids are the indexes of the replicas", k, k)
+		}
+		if _, _, err := net.SplitHostPort(r.Addr); err != nil {
+			e.add("replicas[%v].addr: %v", k, err)
+		} else if other, ok := addrs[r.Addr]; ok {
+			e.add("replicas[%v].addr: %s is also the address of replicas[%v]", k, r.Addr, other)
+		} else {
+			addrs[r.Addr] = k
+		}
+		if r.HTTPAddr != "" {
+			if _, _, err := net.SplitHostPort(r.HTTPAddr); err != nil {
+				e.add("replicas[%v].http_addr: %v", k, err)
+			}
+		}
+	}
+
+	if c.Transport != TransportUDP {
+		e.add("transport: unknown transport %q, supported: %s", c.Transport, TransportUDP)
+	}
+	if c.Codec != CodecGob {
+		e.add("codec: unknown codec %q, supported: %s", c.Codec, CodecGob)
+	}
+	if !knownStateMachine(c.StateMachine) {
+		e.add("state_machine: unknown state machine %q, registered: %s",
+			c.StateMachine, strings.Join(statemachine.Names(), ", "))
+	}
+	if c.Persistence.Enabled && c.Persistence.Dir == "" {
+		e.add("persistence.dir: required if persistence is enabled")
+	}
+
+	durations := []struct {
+		name string
+		d    Duration
+	}{
+		{"timeouts.timeout", c.Timeouts.Timeout},
+		{"timeouts.digest", c.Timeouts.Digest},
+		{"batching.interval", c.Batching.Interval},
+	}
+	for _, v := range durations {
+		if v.d < 0 {
+			e.add("%s: negative duration %v", v.name, time.Duration(v.d))
+		}
+	}
+	values := []struct {
+		name string
+		v    int
+	}{
+		{"batching.max_commands", c.Batching.MaxCommands},
+		{"batching.max_bytes", c.Batching.MaxBytes},
+		{"batching.max_outstanding", c.Batching.MaxOutstanding},
+		{"batching.propose_window", c.Batching.ProposeWindow},
+	}
+	for _, v := range values {
+		if v.v < 0 {
+			e.add("%s: negative value %v", v.name, v.v)
+		}
+	}
+
+	if len(e.Problems) > 0 {
+		return e
+	}
+	return nil
+}
+
+// Addrs returns the transport addresses of the replicas.
+func (c *Config) Addrs() []string {
+	addrs := make([]string, len(c.Replicas))
+	for k, r := range c.Replicas {
+		addrs[k] = r.Addr
+	}
+	return addrs
+}
+
+// PersistentPath returns the path of the store of the replica.
+func (c *Config) PersistentPath(id uint8) string {
+	return filepath.Join(c.Persistence.Dir, fmt.Sprintf("replica-%d", id))
+}
+
+// Param returns the param of the replica, with a new transporter
+// and a new state machine.
+func (c *Config) Param(id uint8, restore bool) (*replica.Param, error) {
+	if int(id) >= len(c.Replicas) {
+		return nil, fmt.Errorf("no replica %v in the config", id)
+	}
+
+	sm, err := statemachine.New(c.StateMachine)
+	if err != nil {
+		return nil, err
+	}
+	tr, err := c.newTransporter(id)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &replica.Param{
+		ReplicaId:       id,
+		Size:            uint8(len(c.Replicas)),
+		CheckpointCycle: c.CheckpointCycle,
+		Addrs:           c.Addrs(),
+		Transporter:     tr,
+		StateMachine:    sm,
+		HTTPAddr:        c.Replicas[id].HTTPAddr,
+
+		TimeoutInterval: time.Duration(c.Timeouts.Timeout),
+		DigestInterval:  time.Duration(c.Timeouts.Digest),
+
+		EnableBatching:         c.Batching.Enabled,
+		BatchInterval:          time.Duration(c.Batching.Interval),
+		EnableAdaptiveBatching: c.Batching.Adaptive,
+		BatchMaxCommands:       c.Batching.MaxCommands,
+		BatchMaxBytes:          c.Batching.MaxBytes,
+		MaxOutstanding:         c.Batching.MaxOutstanding,
+		ProposeWindow:          c.Batching.ProposeWindow,
+
+		EnableForwarding: c.Features.Forwarding,
+		EnableDigest:     c.Features.Digest,
+		EnableTracing:    c.Features.Tracing,
+		EnableAdmin:      c.Features.Admin,
+		EnableClientAPI:  c.Features.Client,
+	}
+	if c.Persistence.Enabled {
+		p.EnablePersistent = true
+		p.PersistentPath = c.PersistentPath(id)
+		p.Restore = restore
+	}
+	return p, nil
+}
+
+func (c *Config) newTransporter(id uint8) (epaxos.Transporter, error) {
+	switch c.Transport {
+	case TransportUDP:
+		return transporter.NewUDPTransporter(c.Addrs(), id, len(c.Replicas))
+	}
+	return nil, fmt.Errorf("unknown transport %q", c.Transport)
+}
+
+func knownStateMachine(name string) bool {
+	for _, n := range statemachine.Names() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
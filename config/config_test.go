@@ -0,0 +1,99 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-distributed/epaxos/statemachine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadExample(t *testing.T) {
+	c, err := Load("example.json")
+	assert.NoError(t, err)
+	assert.Equal(t, len(c.Replicas), 3)
+	assert.Equal(t, c.Replicas[1], Replica{Id: 1, Addr: "127.0.0.1:9001", HTTPAddr: "127.0.0.1:8001"})
+	assert.Equal(t, c.Timeouts.Timeout, Duration(time.Second))
+	assert.Equal(t, c.Batching.Interval, Duration(10*time.Millisecond))
+	assert.Equal(t, c.PersistentPath(2), "/var/lib/epaxos/replica-2")
+	assert.True(t, c.Features.Admin)
+	assert.True(t, c.Features.Client)
+}
+
+func TestParseDefaults(t *testing.T) {
+	c, err := Parse([]byte(`{"replicas": [{"id": 0, "addr": ":9000"}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, c.Transport, TransportUDP)
+	assert.Equal(t, c.Codec, CodecGob)
+	assert.Equal(t, c.StateMachine, DefaultStateMachine)
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse([]byte(`{"replica": []}`))
+	assert.Error(t, err) // unknown field
+
+	_, err = Parse([]byte(`{"replicas": [{"id": 0, "addr": ":9000"}], "timeouts": {"timeout": 50}}`))
+	assert.Error(t, err)
+
+	_, err = Parse([]byte(`{
+		"replicas": [
+			{"id": 0, "addr": ":9000"},
+			{"id": 2, "addr": "localhost"},
+			{"id": 2, "addr": ":9000", "http_addr": "x"},
+			{"id": 3, "addr": ":9003"}
+		],
+		"transport": "tcp",
+		"codec": "protobuf",
+		"state_machine": "sql",
+		"persistence": {"enabled": true},
+		"timeouts": {"digest": "-1s"},
+		"batching": {"max_bytes": -1}
+	}`))
+	verr, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.Equal(t, verr.Problems, []string{
+		"replicas: the number of replicas should be odd, got 4",
+		"replicas[1].id: should be 1, ids are the indexes of the replicas",
+		"replicas[1].addr: address localhost: missing port in address",
+		"replicas[2].addr: :9000 is also the address of replicas[0]",
+		"replicas[2].http_addr: address x: missing port in address",
+		`transport: unknown transport "tcp", supported: udp`,
+		`codec: unknown codec "protobuf", supported: gob`,
+		`state_machine: unknown state machine "sql", registered: counter, kv, noop`,
+		"persistence.dir: required if persistence is enabled",
+		"timeouts.digest: negative duration -1s",
+		"batching.max_bytes: negative value -1",
+	})
+}
+
+func TestParam(t *testing.T) {
+	c, err := Parse([]byte(`{
+		"replicas": [
+			{"id": 0, "addr": "127.0.0.1:0"},
+			{"id": 1, "addr": "127.0.0.1:1", "http_addr": ":8001"},
+			{"id": 2, "addr": "127.0.0.1:2"}
+		],
+		"state_machine": "counter",
+		"persistence": {"enabled": true, "dir": "/tmp"},
+		"timeouts": {"timeout": "2s"},
+		"batching": {"enabled": true, "propose_window": 8}
+	}`))
+	assert.NoError(t, err)
+
+	_, err = c.Param(3, false)
+	assert.Error(t, err)
+
+	p, err := c.Param(0, true)
+	assert.NoError(t, err)
+	assert.Equal(t, p.Size, uint8(3))
+	_, ok := p.StateMachine.(*statemachine.Counter)
+	assert.True(t, ok)
+	assert.Equal(t, p.Addrs, []string{"127.0.0.1:0", "127.0.0.1:1", "127.0.0.1:2"})
+	assert.Equal(t, p.TimeoutInterval, 2*time.Second)
+	assert.True(t, p.EnableBatching)
+	assert.Equal(t, p.ProposeWindow, 8)
+	assert.True(t, p.EnablePersistent)
+	assert.True(t, p.Restore)
+	assert.Equal(t, p.PersistentPath, "/tmp/replica-0")
+	assert.Equal(t, p.HTTPAddr, "")
+}
@@ -40,6 +40,7 @@ func livetestlibSetupCluster(clusterSize int) []*replica.Replica {
 	for i := range nodes {
 		param := &replica.Param{
 			ExecuteInterval: time.Second * 50, // disable execution
+			ExecutePolling:  true,
 			TimeoutInterval: time.Second * 50, // disable timeout
 			ReplicaId:       uint8(i),
 			Size:            uint8(clusterSize),
@@ -67,7 +68,8 @@ func livetestlibSetupEasyTimeoutCluster(clusterSize int) []*replica.Replica {
 
 	for i := range nodes {
 		param := &replica.Param{
-			ExecuteInterval: time.Second * 50,      // disable execution
+			ExecuteInterval: time.Second * 50, // disable execution
+			ExecutePolling:  true,
 			TimeoutInterval: time.Millisecond * 20, // disable timeout
 			ReplicaId:       uint8(i),
 			Size:            uint8(clusterSize),
@@ -90,6 +92,34 @@ func livetestlibSetupEasyTimeoutCluster(clusterSize int) []*replica.Replica {
 	return nodes
 }
 
+func livetestlibSetupExecutionCluster(clusterSize int, polling bool) []*replica.Replica {
+	nodes := make([]*replica.Replica, clusterSize)
+
+	for i := range nodes {
+		param := &replica.Param{
+			TimeoutInterval: time.Second * 50, // disable timeout
+			ExecutePolling:  polling,
+			ReplicaId:       uint8(i),
+			Size:            uint8(clusterSize),
+			StateMachine:    new(test.DummySM),
+			Transporter:     transporter.NewDummyTR(uint8(i), clusterSize),
+		}
+		nodes[i], _ = replica.New(param)
+	}
+
+	chs := make([]chan message.Message, clusterSize)
+	for i := range nodes {
+		chs[i] = nodes[i].MessageChan
+	}
+
+	for i := range nodes {
+		nodes[i].Transporter.(*transporter.DummyTransporter).RegisterChannels(chs)
+		nodes[i].Start()
+	}
+
+	return nodes
+}
+
 func livetestlibStopCluster(nodes []*replica.Replica) {
 	for _, r := range nodes {
 		r.Stop()
@@ -360,3 +390,23 @@ func Test3ProposerConflictTimeout(t *testing.T) {
 		assert.True(t, liveTestlibVerifyDependency(nodes[0], pos))
 	}
 }
+
+// Latency from proposing a command until it is executed on the proposer.
+func benchmarkExecutionLatency(b *testing.B, polling bool) {
+	nodes := livetestlibSetupExecutionCluster(3, polling)
+	defer livetestlibStopCluster(nodes)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := <-nodes[0].Propose(livetestlibExampleCommands(i)...)
+		<-nodes[0].InstanceMatrix[0][id].ExecutedNotify
+	}
+}
+
+func BenchmarkExecutionLatencyPolling(b *testing.B) {
+	benchmarkExecutionLatency(b, true)
+}
+
+func BenchmarkExecutionLatencyNotification(b *testing.B) {
+	benchmarkExecutionLatency(b, false)
+}
@@ -0,0 +1,214 @@
+package logger
+
+// This file defines the Logger interface used by the replica, and its
+// glog and json implementations.
+// @decision(10/18/26):
+// - Info messages have a verbosity level and a component. Levels are
+// - compared to the verbosity of the component, so e.g. the dispatch of
+// - every message can be logged without logging the execution.
+// - Warnings and errors are always logged.
+// - Callers building expensive fields check V() first.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Component of the replica logging a message.
+type Component string
+
+const (
+	Dispatch Component = "dispatch" // message handling and proposals
+	Execute  Component = "execute"  // conflict resolution and execution
+	Timeout  Component = "timeout"  // timeouts and recovery
+	Persist  Component = "persist"  // the persistent store
+)
+
+// Fields of a message, e.g. the replica id, row, instance id,
+// ballot and status.
+type Fields map[string]interface{}
+
+// Verbosity is the max level of info messages logged for each component.
+type Verbosity map[Component]int
+
+type Logger interface {
+	// V returns true if info messages of the component are logged
+	// at the level.
+	V(c Component, level int) bool
+	// Info logs a message if V(c, level) is true.
+	Info(c Component, level int, msg string, fields Fields)
+	Warning(msg string, fields Fields)
+	Error(msg string, fields Fields)
+	// With returns a logger adding the fields to every message.
+	With(fields Fields) Logger
+}
+
+// merge returns the fields of a and b, b wins.
+func merge(a, b Fields) Fields {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	fields := make(Fields, len(a)+len(b))
+	for k, v := range a {
+		fields[k] = v
+	}
+	for k, v := range b {
+		fields[k] = v
+	}
+	return fields
+}
+
+// ******************
+// ****** glog ******
+// ******************
+
+type glogLogger struct {
+	verbosity Verbosity
+	fields    Fields
+}
+
+// NewGlog returns a logger writing to glog. The levels of components
+// missing in verbosity are compared to the -v flag of glog.
+func NewGlog(verbosity Verbosity) Logger {
+	return &glogLogger{verbosity: verbosity}
+}
+
+func (l *glogLogger) V(c Component, level int) bool {
+	if v, ok := l.verbosity[c]; ok {
+		return level <= v
+	}
+	return bool(glog.V(glog.Level(level)))
+}
+
+func (l *glogLogger) Info(c Component, level int, msg string, fields Fields) {
+	if l.V(c, level) {
+		glog.InfoDepth(1, l.format(c, msg, fields))
+	}
+}
+
+func (l *glogLogger) Warning(msg string, fields Fields) {
+	glog.WarningDepth(1, l.format("", msg, fields))
+}
+
+func (l *glogLogger) Error(msg string, fields Fields) {
+	glog.ErrorDepth(1, l.format("", msg, fields))
+}
+
+func (l *glogLogger) With(fields Fields) Logger {
+	return &glogLogger{
+		verbosity: l.verbosity,
+		fields:    merge(l.fields, fields),
+	}
+}
+
+// format returns "[component] msg k1=v1 k2=v2", with the keys sorted.
+func (l *glogLogger) format(c Component, msg string, fields Fields) string {
+	fields = merge(l.fields, fields)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys)+2)
+	if c != "" {
+		parts = append(parts, "["+string(c)+"]")
+	}
+	parts = append(parts, msg)
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, fields[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// ******************
+// ****** json ******
+// ******************
+
+type jsonWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type jsonLogger struct {
+	out       *jsonWriter
+	verbosity Verbosity
+	fields    Fields
+}
+
+// NewJSON returns a logger writing one json object per line to w,
+// with the time, level, component, msg and fields of the message.
+// Info messages of components missing in verbosity are not logged.
+func NewJSON(w io.Writer, verbosity Verbosity) Logger {
+	return &jsonLogger{
+		out:       &jsonWriter{w: w},
+		verbosity: verbosity,
+	}
+}
+
+func (l *jsonLogger) V(c Component, level int) bool {
+	v, ok := l.verbosity[c]
+	return ok && level <= v
+}
+
+func (l *jsonLogger) Info(c Component, level int, msg string, fields Fields) {
+	if l.V(c, level) {
+		l.write("info", c, msg, fields)
+	}
+}
+
+func (l *jsonLogger) Warning(msg string, fields Fields) {
+	l.write("warning", "", msg, fields)
+}
+
+func (l *jsonLogger) Error(msg string, fields Fields) {
+	l.write("error", "", msg, fields)
+}
+
+func (l *jsonLogger) With(fields Fields) Logger {
+	return &jsonLogger{
+		out:       l.out,
+		verbosity: l.verbosity,
+		fields:    merge(l.fields, fields),
+	}
+}
+
+func (l *jsonLogger) write(level string, c Component, msg string, fields Fields) {
+	fields = merge(l.fields, fields)
+	entry := make(map[string]interface{}, len(fields)+4)
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = msg
+	if c != "" {
+		entry["component"] = string(c)
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		// fall back to the string values of the fields
+		for k, v := range entry {
+			entry[k] = fmt.Sprint(v)
+		}
+		b, _ = json.Marshal(entry)
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(append(b, '\n'))
+}
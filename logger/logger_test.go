@@ -0,0 +1,71 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loggerTestlibDecode(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := make(map[string]interface{})
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		delete(entry, "time")
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestJSONVerbosity(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := NewJSON(buf, Verbosity{Dispatch: 2, Execute: 1})
+
+	assert.True(t, l.V(Dispatch, 2))
+	assert.True(t, l.V(Execute, 1))
+	assert.False(t, l.V(Execute, 2))
+	assert.False(t, l.V(Timeout, 1))
+
+	l.Info(Dispatch, 2, "recv", nil)
+	l.Info(Execute, 2, "push stack", nil)
+	l.Info(Timeout, 1, "drop timeout", nil)
+	l.Error("failed", nil)
+
+	assert.Equal(t, loggerTestlibDecode(t, buf), []map[string]interface{}{
+		{"level": "info", "component": "dispatch", "msg": "recv"},
+		{"level": "error", "msg": "failed"},
+	})
+}
+
+func TestJSONFields(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := NewJSON(buf, Verbosity{Dispatch: 1}).With(Fields{"replica": 1, "row": 0})
+
+	l.Info(Dispatch, 1, "status", Fields{"row": 2, "status": "Committed"})
+	l.With(Fields{"instance": 3}).Warning("slow", Fields{"err": errors.New("e")})
+
+	assert.Equal(t, loggerTestlibDecode(t, buf), []map[string]interface{}{
+		{"level": "info", "component": "dispatch", "msg": "status",
+			"replica": float64(1), "row": float64(2), "status": "Committed"},
+		{"level": "warning", "msg": "slow", "err": "e",
+			"replica": float64(1), "row": float64(0), "instance": float64(3)},
+	})
+}
+
+func TestGlogFormat(t *testing.T) {
+	l := NewGlog(Verbosity{Dispatch: 1}).(*glogLogger)
+	assert.True(t, l.V(Dispatch, 1))
+	assert.False(t, l.V(Dispatch, 2))
+
+	l = l.With(Fields{"replica": 1}).(*glogLogger)
+	assert.Equal(t, l.format(Dispatch, "recv", Fields{"row": 2, "ballot": "1.0.0"}),
+		"[dispatch] recv ballot=1.0.0 replica=1 row=2")
+	assert.Equal(t, l.format("", "failed", nil), "failed replica=1")
+}
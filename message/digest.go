@@ -0,0 +1,45 @@
+package message
+
+import (
+	"fmt"
+)
+
+// Digest carries the execution hashes of a replica, so that replicas can
+// verify they execute the same commands with the same results.
+type Digest struct {
+	From uint8
+	Rows []RowDigest
+}
+
+// RowDigest holds the rolling hashes of the instances executed in one
+// instance space. Hashes[k] is the hash at instance Start+k, 0 if the
+// instance has no hash, e.g. it's a checkpoint.
+type RowDigest struct {
+	RowId  uint8
+	Start  uint64
+	Hashes []uint64
+}
+
+func (d *Digest) Sender() uint8 {
+	return d.From
+}
+
+func (d *Digest) Type() uint8 {
+	return DigestMsg
+}
+
+func (d *Digest) Content() interface{} {
+	return d
+}
+
+func (d *Digest) Replica() uint8 {
+	return d.From
+}
+
+func (d *Digest) Instance() uint64 {
+	return 0
+}
+
+func (d *Digest) String() string {
+	return fmt.Sprintf("Digest, from %v, %v rows", d.From, len(d.Rows))
+}
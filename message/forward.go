@@ -0,0 +1,74 @@
+package message
+
+import (
+	"fmt"
+)
+
+// Forward asks a replica to propose commands for the sender, which can't
+// reach a quorum. Path holds the replicas the commands were forwarded by,
+// so they are not forwarded back. (From, ForwardId) identifies a forward,
+// a resent one is not proposed again.
+type Forward struct {
+	From      uint8
+	ForwardId uint64
+	Path      []uint8
+	Cmds      Commands
+}
+
+// ForwardReply returns the results of forwarded commands
+// once they are executed.
+type ForwardReply struct {
+	From       uint8
+	ForwardId  uint64
+	ReplicaId  uint8
+	InstanceId uint64
+	Results    []interface{}
+}
+
+func (f *Forward) Sender() uint8 {
+	return f.From
+}
+
+func (f *Forward) Type() uint8 {
+	return ForwardMsg
+}
+
+func (f *Forward) Content() interface{} {
+	return f
+}
+
+func (f *Forward) Replica() uint8 {
+	return f.From
+}
+
+func (f *Forward) Instance() uint64 {
+	return 0
+}
+
+func (f *Forward) String() string {
+	return fmt.Sprintf("Forward %v, from %v, path %v", f.ForwardId, f.From, f.Path)
+}
+
+func (f *ForwardReply) Sender() uint8 {
+	return f.From
+}
+
+func (f *ForwardReply) Type() uint8 {
+	return ForwardReplyMsg
+}
+
+func (f *ForwardReply) Content() interface{} {
+	return f
+}
+
+func (f *ForwardReply) Replica() uint8 {
+	return f.ReplicaId
+}
+
+func (f *ForwardReply) Instance() uint64 {
+	return f.InstanceId
+}
+
+func (f *ForwardReply) String() string {
+	return fmt.Sprintf("ForwardReply %v, Instance[%v][%v]", f.ForwardId, f.ReplicaId, f.InstanceId)
+}
@@ -29,6 +29,12 @@ func MessageTypeString(m Message) string {
 		return "Prepare"
 	case PrepareReplyMsg:
 		return "PrepareReply"
+	case DigestMsg:
+		return "Digest"
+	case ForwardMsg:
+		return "Forward"
+	case ForwardReplyMsg:
+		return "ForwardReply"
 	default:
 		panic("")
 	}
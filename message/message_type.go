@@ -11,4 +11,7 @@ const (
 	PrepareMsg
 	PrepareReplyMsg
 	TimeoutMsg
+	DigestMsg
+	ForwardMsg
+	ForwardReplyMsg
 )
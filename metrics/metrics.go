@@ -0,0 +1,317 @@
+package metrics
+
+// This file implements a minimal metrics registry. It's exposed in the
+// prometheus text format, so it can be scraped without any client library.
+// @decision(10/18/26):
+// - Series are registered once, by the component owning them. Registering
+// - the same name and labels twice is a bug, and panics.
+// - Gauges computed from the state of the replica are functions, called on
+// - every scrape, instead of being updated on every change.
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric types
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+// Labels of a series, e.g. {"row": "1"}.
+type Labels map[string]string
+
+// Counter is a value which only increases.
+type Counter struct {
+	v uint64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.v, 1)
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.v, n)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+// Gauge is a value which can go up and down.
+type Gauge struct {
+	bits uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Histogram counts observed values in buckets.
+type Histogram struct {
+	mu     sync.Mutex
+	upper  []float64 // upper bounds of the buckets, increasing
+	counts []uint64  // not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	upper := append([]float64{}, buckets...)
+	sort.Float64s(upper)
+	return &Histogram{
+		upper:  upper,
+		counts: make([]uint64, len(upper)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sum += v
+	h.count++
+	for i, u := range h.upper {
+		if v <= u {
+			h.counts[i]++
+			return
+		}
+	}
+}
+
+// Count returns the number of observed values.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// ExponentialBuckets returns n bucket bounds, starting from start,
+// each factor times the previous one.
+func ExponentialBuckets(start, factor float64, n int) []float64 {
+	buckets := make([]float64, n)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+type series struct {
+	labels string // formatted
+	write  func(w io.Writer, name string, labels string) error
+}
+
+type family struct {
+	name   string
+	help   string
+	typ    string
+	series []*series
+}
+
+// Registry is a set of metrics, it serves them over http in
+// the prometheus text format.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+	}
+}
+
+func (r *Registry) register(name, help, typ string, labels Labels, s *series) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{name: name, help: help, typ: typ}
+		r.families[name] = f
+	}
+	if f.typ != typ {
+		panic(fmt.Sprintf("metrics: %s registered as %s and %s", name, f.typ, typ))
+	}
+
+	s.labels = formatLabels(labels)
+	for _, other := range f.series {
+		if other.labels == s.labels {
+			panic(fmt.Sprintf("metrics: duplicate series %s%s", name, s.labels))
+		}
+	}
+	f.series = append(f.series, s)
+}
+
+// Counter registers a new counter.
+func (r *Registry) Counter(name, help string, labels Labels) *Counter {
+	c := new(Counter)
+	r.register(name, help, counterType, labels, &series{
+		write: func(w io.Writer, name string, labels string) error {
+			_, err := fmt.Fprintf(w, "%s%s %d\n", name, labels, c.Value())
+			return err
+		},
+	})
+	return c
+}
+
+// CounterFunc registers a counter whose value is returned by f.
+func (r *Registry) CounterFunc(name, help string, labels Labels, f func() uint64) {
+	r.register(name, help, counterType, labels, &series{
+		write: func(w io.Writer, name string, labels string) error {
+			_, err := fmt.Fprintf(w, "%s%s %d\n", name, labels, f())
+			return err
+		},
+	})
+}
+
+// Gauge registers a new gauge.
+func (r *Registry) Gauge(name, help string, labels Labels) *Gauge {
+	g := new(Gauge)
+	r.GaugeFunc(name, help, labels, g.Value)
+	return g
+}
+
+// GaugeFunc registers a gauge whose value is returned by f.
+func (r *Registry) GaugeFunc(name, help string, labels Labels, f func() float64) {
+	r.register(name, help, gaugeType, labels, &series{
+		write: func(w io.Writer, name string, labels string) error {
+			_, err := fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(f()))
+			return err
+		},
+	})
+}
+
+// Histogram registers a new histogram with the bucket upper bounds.
+func (r *Registry) Histogram(name, help string, labels Labels, buckets []float64) *Histogram {
+	h := newHistogram(buckets)
+	r.register(name, help, histogramType, labels, &series{
+		write: func(w io.Writer, name string, labels string) error {
+			return h.write(w, name, labels)
+		},
+	})
+	return h
+}
+
+func (h *Histogram) write(w io.Writer, name string, labels string) error {
+	h.mu.Lock()
+	counts := append([]uint64{}, h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	// the le label is added to the other labels
+	prefix := "{"
+	if labels != "" {
+		prefix = labels[:len(labels)-1] + ","
+	}
+
+	var cumulative uint64
+	for i, u := range h.upper {
+		cumulative += counts[i]
+		if _, err := fmt.Fprintf(w, "%s_bucket%sle=\"%s\"} %d\n",
+			name, prefix, formatFloat(u), cumulative); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "%s_bucket%sle=\"+Inf\"} %d\n", name, prefix, count); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(sum)); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s_count%s %d\n", name, labels, count)
+	return err
+}
+
+// WriteText writes all metrics in the prometheus text format,
+// sorted by name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Sort(byName(families))
+
+	for _, f := range families {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n",
+			f.name, escapeHelp(f.help), f.name, f.typ); err != nil {
+			return err
+		}
+		for _, s := range f.series {
+			if err := s.write(w, f.name, s.labels); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteText(w)
+}
+
+type byName []*family
+
+func (f byName) Len() int           { return len(f) }
+func (f byName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f byName) Less(i, j int) bool { return f[i].name < f[j].name }
+
+// formatLabels returns the labels as {k1="v1",k2="v2"}, sorted by key,
+// or "" if there are none.
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", k, escapeLabel(labels[k]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func escapeHelp(v string) string {
+	return helpEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteText(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("b_total", "A counter.", Labels{"path": "fast"})
+	reg.Counter("b_total", "A counter.", Labels{"path": "slow"}).Add(3)
+	c.Inc()
+	g := reg.Gauge("a", "A gauge\nwith two lines.", nil)
+	g.Set(1.5)
+	reg.GaugeFunc("c", "A gauge func.", Labels{"v": `a"b\`}, func() float64 { return 2 })
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, reg.WriteText(buf))
+	assert.Equal(t, buf.String(), `# HELP a A gauge\nwith two lines.
+# TYPE a gauge
+a 1.5
+# HELP b_total A counter.
+# TYPE b_total counter
+b_total{path="fast"} 1
+b_total{path="slow"} 3
+# HELP c A gauge func.
+# TYPE c gauge
+c{v="a\"b\\"} 2
+`)
+}
+
+func TestHistogram(t *testing.T) {
+	reg := NewRegistry()
+	h := reg.Histogram("h", "A histogram.", Labels{"row": "1"}, []float64{4, 1, 2})
+	for _, v := range []float64{1, 1, 3, 8} {
+		h.Observe(v)
+	}
+	assert.Equal(t, h.Count(), uint64(4))
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, reg.WriteText(buf))
+	assert.Equal(t, buf.String(), `# HELP h A histogram.
+# TYPE h histogram
+h_bucket{row="1",le="1"} 2
+h_bucket{row="1",le="2"} 2
+h_bucket{row="1",le="4"} 3
+h_bucket{row="1",le="+Inf"} 4
+h_sum{row="1"} 13
+h_count{row="1"} 4
+`)
+}
+
+func TestDuplicateSeries(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("a", "", Labels{"x": "1"})
+	reg.Counter("a", "", Labels{"x": "2"})
+	assert.Panics(t, func() { reg.Counter("a", "", Labels{"x": "1"}) })
+	assert.Panics(t, func() { reg.Gauge("a", "", nil) })
+}
+
+func TestServeHTTP(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("a", "", nil).Inc()
+
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, w.Header().Get("Content-Type"), "text/plain; version=0.0.4")
+	assert.Equal(t, w.Body.String(), "# HELP a \n# TYPE a counter\na 1\n")
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	assert.Equal(t, ExponentialBuckets(1, 2, 4), []float64{1, 2, 4, 8})
+}
@@ -1,6 +1,9 @@
 package persistent
 
 import (
+	"errors"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -9,10 +12,16 @@ import (
 	"github.com/go-distributed/epaxos"
 )
 
+var (
+	ErrReadOnly = errors.New("persistent: opened read-only")
+)
+
 type LevelDB struct {
-	fpath string
-	ldb   *leveldb.DB
-	wsync *db.WriteOptions
+	fpath    string
+	ldb      *leveldb.DB
+	wsync    *db.WriteOptions
+	readOnly bool
+	tmpDir   string // the copy opened read-only, removed on Close
 }
 
 func NewLevelDB(path string, restore bool) (*LevelDB, error) {
@@ -41,7 +50,84 @@ func NewLevelDB(path string, restore bool) (*LevelDB, error) {
 	return ret, nil
 }
 
+// OpenReadOnly opens the existing store at path, without deleting it,
+// e.g. to inspect the store of a stopped replica. Writing returns
+// ErrReadOnly.
+// leveldb has no read-only mode, opening a store writes its LOCK, log and
+// manifest files, so the store is copied to a temporary directory which is
+// opened instead, and removed on Close. The store at path is never written.
+func OpenReadOnly(path string) (*LevelDB, error) {
+	fpath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	// leveldb.Open would create it
+	if _, err := os.Stat(fpath); err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := ioutil.TempDir("", "epaxos-readonly-")
+	if err != nil {
+		return nil, err
+	}
+	if err := copyStore(fpath, tmpDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+
+	ldb, err := leveldb.Open(tmpDir, nil)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	return &LevelDB{
+		fpath:    fpath,
+		ldb:      ldb,
+		readOnly: true,
+		tmpDir:   tmpDir,
+	}, nil
+}
+
+// copyStore copies the files of the store at src to dst,
+// except the LOCK file of a replica which may be running.
+func copyStore(src, dst string) error {
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() || fi.Name() == "LOCK" {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, fi.Name()), filepath.Join(dst, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
 func (l *LevelDB) Put(key string, value []byte) error {
+	if l.readOnly {
+		return ErrReadOnly
+	}
 	return l.ldb.Set([]byte(key), value, l.wsync)
 }
 
@@ -54,10 +140,16 @@ func (l *LevelDB) Get(key string) ([]byte, error) {
 }
 
 func (l *LevelDB) Delete(key string) error {
+	if l.readOnly {
+		return ErrReadOnly
+	}
 	return l.ldb.Delete([]byte(key), l.wsync)
 }
 
 func (l *LevelDB) BatchPut(kvs []*epaxos.KVpair) error {
+	if l.readOnly {
+		return ErrReadOnly
+	}
 	b := new(leveldb.Batch)
 	for i := range kvs {
 		b.Set([]byte(kvs[i].Key), kvs[i].Value)
@@ -66,9 +158,16 @@ func (l *LevelDB) BatchPut(kvs []*epaxos.KVpair) error {
 }
 
 func (l *LevelDB) Close() error {
-	return l.ldb.Close()
+	err := l.ldb.Close()
+	if l.tmpDir != "" {
+		os.RemoveAll(l.tmpDir)
+	}
+	return err
 }
 
 func (l *LevelDB) Drop() error {
+	if l.readOnly {
+		return ErrReadOnly
+	}
 	return os.RemoveAll(l.fpath)
 }
@@ -1,6 +1,9 @@
 package persistent
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/golang/leveldb/db"
@@ -67,3 +70,45 @@ func TestBatchPut(t *testing.T) {
 	v, err = l.Get("epaxos")
 	assert.Equal(t, v, []byte("rocks"))
 }
+
+// testlibFiles lists the files in dir, with their sizes and times.
+func testlibFiles(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	res := make([]string, len(files))
+	for i, fi := range files {
+		res[i] = fmt.Sprintf("%v %v %v", fi.Name(), fi.Size(), fi.ModTime())
+	}
+	return res
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	_, err := OpenReadOnly("/tmp/test-missing")
+	assert.Error(t, err)
+
+	l, err := NewLevelDB("/tmp/test", false)
+	assert.NoError(t, err)
+	defer l.Drop()
+	assert.NoError(t, l.Put("hello", []byte("world")))
+	assert.NoError(t, l.Close())
+
+	before := testlibFiles(t, "/tmp/test")
+	ro, err := OpenReadOnly("/tmp/test")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, ro.Close())
+		_, err := os.Stat(ro.tmpDir)
+		assert.True(t, os.IsNotExist(err))
+		// the store is not written
+		assert.Equal(t, testlibFiles(t, "/tmp/test"), before)
+	}()
+
+	v, err := ro.Get("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, v, []byte("world"))
+
+	assert.Equal(t, ro.Put("hello", []byte("epaxos")), ErrReadOnly)
+	assert.Equal(t, ro.Delete("hello"), ErrReadOnly)
+	assert.Equal(t, ro.BatchPut(nil), ErrReadOnly)
+	assert.Equal(t, ro.Drop(), ErrReadOnly)
+}
@@ -0,0 +1,402 @@
+package replica
+
+// This file implements the read-only admin api of the replica, served as
+// json on the http endpoint if EnableAdmin is set, see operations.go for
+// the admin operations:
+// - /admin/replica: the packed replica, and the state of each row.
+// - /admin/instances/<row>: the instances of the row not executed yet.
+// - /admin/instances/<row>/<id>: the details of one instance.
+// - /admin/blocked: the chains of instances blocking the execution.
+// - /admin/graph: the dependency graph, see graph.go.
+// - /admin/peers: when each peer was last heard from.
+// @decision(10/18/26):
+// - Like the metrics, the instance matrix is read holding the replica lock,
+// - and the handlers only serve copies made under it, so each response is
+// - a consistent snapshot taken between two events of the event loop.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-distributed/epaxos/message"
+)
+
+var (
+	ErrInstanceNotFound = errors.New("Instance not found")
+)
+
+// the max number of instances listed for a row, and the max length
+// of a blocked chain
+const (
+	maxAdminInstances = 1024
+	maxBlockedChain   = 64
+)
+
+// RowStatus is the state of an instance space.
+type RowStatus struct {
+	RowId          uint8
+	MaxInstanceNum uint64
+	ExecutedUpTo   uint64
+	Lag            uint64 // instances not executed yet
+	Foreign        uint64 `json:",omitempty"` // the highest foreign instance, with ownership
+}
+
+// ReplicaStatus is the state of the replica served on /admin/replica.
+type ReplicaStatus struct {
+	Replica    *PackedReplica
+	Epoch      uint32
+	Rows       []RowStatus
+	Window     WindowStats
+	QuorumLost bool
+	Divergence *Divergence `json:",omitempty"`
+	Err        string      `json:",omitempty"` // the error which stopped the execution
+}
+
+// RecoveryStatus is the recovery info of an instance being prepared.
+type RecoveryStatus struct {
+	Ballot       string
+	Status       string
+	FormerStatus string
+	ReplyCount   int `json:",omitempty"`
+	Cmds         []string
+	Deps         message.Dependencies
+}
+
+// InstanceStatus is the state of an instance.
+type InstanceStatus struct {
+	RowId    uint8
+	Id       uint64
+	Status   string
+	Ballot   string
+	Cmds     []string
+	Deps     message.Dependencies
+	Executed bool
+	Recovery *RecoveryStatus `json:",omitempty"`
+}
+
+// BlockedChain is a chain of instances, starting from the first instance
+// of a row not executed yet. Each instance in the chain waits for the next
+// one, the last one is not committed yet if Blocked is true.
+type BlockedChain struct {
+	RowId   uint8
+	Chain   []*InstanceStatus
+	Blocked bool
+}
+
+// PeerStatus describes when a peer was last heard from.
+type PeerStatus struct {
+	Id        uint8
+	LastHeard time.Time
+	Alive     bool // heard from within the timeout interval
+}
+
+// statusName is statusString, which doesn't panic on statuses
+// read from the store or not set.
+func statusName(status uint8) string {
+	if status == 0 {
+		return ""
+	}
+	if status > committed {
+		return fmt.Sprintf("Unknown(%v)", status)
+	}
+	return statusString(status)
+}
+
+func commandStrings(cmds message.Commands) []string {
+	if cmds == nil {
+		return nil
+	}
+	res := make([]string, len(cmds))
+	for k, cmd := range cmds {
+		res[k] = string(cmd)
+	}
+	return res
+}
+
+// Status returns the state of the replica.
+func (r *Replica) Status() *ReplicaStatus {
+	s := &ReplicaStatus{
+		Rows:       make([]RowStatus, r.Size),
+		Window:     r.WindowStats(),
+		QuorumLost: r.QuorumLost(),
+		Divergence: r.Divergence(),
+	}
+
+	r.lock.Lock()
+	s.Replica = r.Pack()
+	s.Epoch = r.Epoch
+	for row := uint8(0); row < r.Size; row++ {
+		s.Rows[row] = RowStatus{
+			RowId:          row,
+			MaxInstanceNum: s.Replica.MaxInstanceNum[row],
+			ExecutedUpTo:   s.Replica.ExecutedUpTo[row],
+		}
+		if s.Rows[row].MaxInstanceNum > s.Rows[row].ExecutedUpTo {
+			s.Rows[row].Lag = s.Rows[row].MaxInstanceNum - s.Rows[row].ExecutedUpTo
+		}
+		if r.foreignUpTo != nil {
+			s.Rows[row].Foreign = r.foreignUpTo[row]
+		}
+	}
+	r.lock.Unlock()
+
+	if err := r.Err(); err != nil {
+		s.Err = err.Error()
+	}
+	return s
+}
+
+func (i *Instance) adminStatus() *InstanceStatus {
+	s := &InstanceStatus{
+		RowId:    i.rowId,
+		Id:       i.id,
+		Status:   i.StatusString(),
+		Ballot:   i.ballot.String(),
+		Cmds:     commandStrings(i.cmds),
+		Deps:     i.deps.Clone(),
+		Executed: i.executed,
+	}
+	if ri := i.recoveryInfo; ri != nil && i.isAtStatus(preparing) {
+		s.Recovery = &RecoveryStatus{
+			Status:       statusName(ri.status),
+			FormerStatus: statusName(ri.formerStatus),
+			ReplyCount:   ri.replyCount,
+			Cmds:         commandStrings(ri.cmds),
+			Deps:         ri.deps.Clone(),
+		}
+		if ri.ballot != nil {
+			s.Recovery.Ballot = ri.ballot.String()
+		}
+	}
+	return s
+}
+
+// NewInstanceStatus returns the state of a packed instance,
+// e.g. loaded from the store by LoadInstance.
+func NewInstanceStatus(p *PackedInstance) *InstanceStatus {
+	s := &InstanceStatus{
+		RowId:    p.RowId,
+		Id:       p.Id,
+		Status:   statusName(p.Status),
+		Cmds:     commandStrings(p.Cmds),
+		Deps:     p.Deps,
+		Executed: p.Executed,
+	}
+	if p.Ballot != nil {
+		s.Ballot = p.Ballot.String()
+	}
+	if pr := p.PackedRecoveryInfo; pr != nil && p.Status == preparing {
+		s.Recovery = &RecoveryStatus{
+			Status:       statusName(pr.Status),
+			FormerStatus: statusName(pr.FormerStatus),
+			Cmds:         commandStrings(pr.Cmds),
+			Deps:         pr.Deps,
+		}
+		if pr.Ballot != nil {
+			s.Recovery.Ballot = pr.Ballot.String()
+		}
+	}
+	return s
+}
+
+// instanceAt returns the instance, or nil if it's out of the matrix.
+func (r *Replica) instanceAt(rowId uint8, id uint64) *Instance {
+	if rowId >= r.Size || id >= uint64(len(r.InstanceMatrix[rowId])) {
+		return nil
+	}
+	return r.InstanceMatrix[rowId][id]
+}
+
+// InstanceStatus returns the state of an instance.
+func (r *Replica) InstanceStatus(rowId uint8, id uint64) (*InstanceStatus, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	i := r.instanceAt(rowId, id)
+	if i == nil {
+		return nil, ErrInstanceNotFound
+	}
+	return i.adminStatus(), nil
+}
+
+// RowInstances returns the state of the instances of the row
+// not executed yet, at most maxAdminInstances of them.
+func (r *Replica) RowInstances(rowId uint8) ([]*InstanceStatus, error) {
+	if rowId >= r.Size {
+		return nil, ErrInstanceNotFound
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	res := make([]*InstanceStatus, 0)
+	for id := r.ExecutedUpTo[rowId] + 1; id <= r.MaxInstanceNum[rowId]; id++ {
+		if len(res) == maxAdminInstances {
+			break
+		}
+		if i := r.instanceAt(rowId, id); i != nil && !i.executed {
+			res = append(res, i.adminStatus())
+		}
+	}
+	return res, nil
+}
+
+// BlockedChains returns the chain blocking the execution of each row
+// which has instances not executed yet.
+func (r *Replica) BlockedChains() []*BlockedChain {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	res := make([]*BlockedChain, 0)
+	for row := uint8(0); row < r.Size; row++ {
+		if r.firstNotExecuted(row) <= r.MaxInstanceNum[row] {
+			res = append(res, r.blockedChain(row))
+		}
+	}
+	return res
+}
+
+// firstNotExecuted returns the first instance of the row not executed yet.
+// Checkpoints are skipped, they are never in the matrix.
+func (r *Replica) firstNotExecuted(rowId uint8) uint64 {
+	id := r.ExecutedUpTo[rowId] + 1
+	for r.IsCheckpoint(id) {
+		id++
+	}
+	return id
+}
+
+// blockedChain follows the dependencies from the first instance of the row
+// not executed yet. Instances of a row are executed in order, so a
+// dependency on an instance waits for the first instance of its row not
+// executed yet. The chain stops at an instance not committed, or when all
+// the dependencies are already in the chain, i.e. they are in the same scc.
+// It's called holding the lock.
+func (r *Replica) blockedChain(rowId uint8) *BlockedChain {
+	bc := &BlockedChain{RowId: rowId}
+	visited := make(map[instanceRef]bool)
+
+	ref := instanceRef{rowId, r.firstNotExecuted(rowId)}
+	for len(bc.Chain) < maxBlockedChain {
+		i := r.instanceAt(ref.rowId, ref.id)
+		if i == nil {
+			bc.Chain = append(bc.Chain, &InstanceStatus{
+				RowId:  ref.rowId,
+				Id:     ref.id,
+				Status: statusString(nilStatus),
+			})
+			bc.Blocked = true
+			return bc
+		}
+		bc.Chain = append(bc.Chain, i.adminStatus())
+		visited[ref] = true
+		if !i.isAtStatus(committed) {
+			bc.Blocked = true
+			return bc
+		}
+
+		next, found := instanceRef{}, false
+		for dep, id := range i.deps {
+			if id <= r.ExecutedUpTo[dep] || r.IsCheckpoint(id) {
+				continue
+			}
+			head := instanceRef{uint8(dep), r.firstNotExecuted(uint8(dep))}
+			if !visited[head] {
+				next, found = head, true
+				break
+			}
+		}
+		if !found {
+			return bc
+		}
+		ref = next
+	}
+	return bc
+}
+
+// Peers returns when each peer was last heard from.
+func (r *Replica) Peers() []PeerStatus {
+	r.forwardLock.Lock()
+	defer r.forwardLock.Unlock()
+
+	peers := make([]PeerStatus, 0, r.Size-1)
+	for peer, heard := range r.lastHeard {
+		if uint8(peer) == r.Id {
+			continue
+		}
+		peers = append(peers, PeerStatus{
+			Id:        uint8(peer),
+			LastHeard: heard,
+			Alive:     !heard.IsZero() && time.Since(heard) <= r.TimeoutInterval,
+		})
+	}
+	return peers
+}
+
+// ******************
+// ****** http ******
+// ******************
+
+func (r *Replica) initAdmin() {
+	r.httpMux.HandleFunc("/admin/replica", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, r.Status())
+	})
+	r.httpMux.HandleFunc("/admin/instances/", r.serveInstances)
+	r.httpMux.HandleFunc("/admin/blocked", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, r.BlockedChains())
+	})
+	r.httpMux.HandleFunc("/admin/graph", r.serveGraph)
+	r.httpMux.HandleFunc("/admin/peers", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, r.Peers())
+	})
+	r.initOperations()
+}
+
+// serveInstances serves /admin/instances/<row> and
+// /admin/instances/<row>/<id>.
+func (r *Replica) serveInstances(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/admin/instances/"), "/"), "/")
+	if len(parts) > 2 {
+		http.NotFound(w, req)
+		return
+	}
+	row, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil {
+		http.Error(w, "invalid row", http.StatusBadRequest)
+		return
+	}
+
+	if len(parts) == 1 {
+		instances, err := r.RowInstances(uint8(row))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, instances)
+		return
+	}
+
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid instance id", http.StatusBadRequest)
+		return
+	}
+	s, err := r.InstanceStatus(uint8(row), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, s)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,161 @@
+package replica
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/test"
+	"github.com/go-distributed/epaxos/transporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func adminTestlibReplica() *Replica {
+	r, err := New(&Param{
+		ReplicaId:    0,
+		Size:         5,
+		StateMachine: new(test.DummySM),
+		Transporter:  transporter.NewDummyTR(0, 5),
+		EnableAdmin:  true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func adminTestlibAdd(r *Replica, row uint8, id uint64, status uint8, deps message.Dependencies) *Instance {
+	i := NewInstance(r, row, id)
+	i.status = status
+	i.deps = deps
+	i.cmds = message.Commands{message.Command("a")}
+	r.InstanceMatrix[row][id] = i
+	if r.MaxInstanceNum[row] < id {
+		r.MaxInstanceNum[row] = id
+	}
+	return i
+}
+
+func adminTestlibGet(r *Replica, path string, v interface{}) int {
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	if w.Code == 200 && v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			panic(err)
+		}
+	}
+	return w.Code
+}
+
+func adminTestlibRefs(chain *BlockedChain) []instanceRef {
+	refs := make([]instanceRef, len(chain.Chain))
+	for k, s := range chain.Chain {
+		refs[k] = instanceRef{s.RowId, s.Id}
+	}
+	return refs
+}
+
+// The chain should follow the first instances not executed in each row,
+// until an instance not committed.
+func TestBlockedChains(t *testing.T) {
+	r := adminTestlibReplica()
+	adminTestlibAdd(r, 0, 1, committed, message.Dependencies{0, 1, 0, 0, 0})
+	adminTestlibAdd(r, 1, 1, committed, message.Dependencies{1, 0, 3, 0, 0})
+	adminTestlibAdd(r, 2, 1, preAccepted, message.Dependencies{0, 0, 0, 0, 0})
+	r.MaxInstanceNum[2] = 3
+
+	chains := r.BlockedChains()
+	assert.Equal(t, len(chains), 3)
+	assert.True(t, chains[0].Blocked)
+	assert.Equal(t, adminTestlibRefs(chains[0]), []instanceRef{{0, 1}, {1, 1}, {2, 1}})
+	assert.Equal(t, chains[0].Chain[2].Status, "PreAccepted")
+
+	// an scc waiting for execution
+	r.InstanceMatrix[1][1].deps = message.Dependencies{1, 0, 0, 0, 0}
+	chain := r.blockedChain(1)
+	assert.False(t, chain.Blocked)
+	assert.Equal(t, adminTestlibRefs(chain), []instanceRef{{1, 1}, {0, 1}})
+
+	// a missing instance
+	r.ExecutedUpTo[2] = 1
+	chain = r.blockedChain(2)
+	assert.True(t, chain.Blocked)
+	assert.Equal(t, adminTestlibRefs(chain), []instanceRef{{2, 2}})
+	assert.Equal(t, chain.Chain[0].Status, "NilStatus")
+}
+
+// Checkpoints should be neither the start of a chain nor a blocking dep.
+func TestBlockedChainsCheckpoint(t *testing.T) {
+	r := adminTestlibReplica()
+	adminTestlibAdd(r, 0, 1025, committed, message.Dependencies{0, 1024, 0, 0, 0})
+	r.ExecutedUpTo[0] = 1023
+	r.ExecutedUpTo[1] = 1023
+	r.MaxInstanceNum[1] = 1024
+
+	chains := r.BlockedChains()
+	assert.Equal(t, len(chains), 1)
+	assert.False(t, chains[0].Blocked)
+	assert.Equal(t, adminTestlibRefs(chains[0]), []instanceRef{{0, 1025}})
+}
+
+func TestAdminHTTP(t *testing.T) {
+	r := adminTestlibReplica()
+	adminTestlibAdd(r, 1, 1, committed, message.Dependencies{0, 0, 0, 0, 0})
+	i := adminTestlibAdd(r, 1, 2, accepted, message.Dependencies{0, 1, 0, 0, 0})
+	r.ExecutedUpTo[1] = 1
+	r.heardFrom(2)
+
+	var status ReplicaStatus
+	assert.Equal(t, adminTestlibGet(r, "/admin/replica", &status), 200)
+	assert.Equal(t, status.Replica.MaxInstanceNum, []uint64{0, 2, 0, 0, 0})
+	assert.Equal(t, status.Rows[1], RowStatus{RowId: 1, MaxInstanceNum: 2, ExecutedUpTo: 1, Lag: 1})
+
+	var instances []*InstanceStatus
+	assert.Equal(t, adminTestlibGet(r, "/admin/instances/1", &instances), 200)
+	assert.Equal(t, len(instances), 1)
+	assert.Equal(t, instances[0].Id, uint64(2))
+
+	// a recovering instance
+	i.enterPreparing()
+	var s InstanceStatus
+	assert.Equal(t, adminTestlibGet(r, "/admin/instances/1/2", &s), 200)
+	assert.Equal(t, s.Status, "Preparing")
+	assert.Equal(t, s.Cmds, []string{"a"})
+	assert.Equal(t, s.Deps, message.Dependencies{0, 1, 0, 0, 0})
+	assert.Equal(t, s.Ballot, i.ballot.String())
+	assert.Equal(t, s.Recovery.Ballot, "0.0.0")
+	assert.Equal(t, s.Recovery.FormerStatus, "Accepted")
+
+	assert.Equal(t, adminTestlibGet(r, "/admin/instances/1/3", nil), 404)
+	assert.Equal(t, adminTestlibGet(r, "/admin/instances/9", nil), 404)
+	assert.Equal(t, adminTestlibGet(r, "/admin/instances/x/1", nil), 400)
+
+	var peers []PeerStatus
+	assert.Equal(t, adminTestlibGet(r, "/admin/peers", &peers), 200)
+	assert.Equal(t, len(peers), 4)
+	assert.Equal(t, peers[1].Id, uint8(2))
+	assert.True(t, peers[1].Alive)
+	assert.False(t, peers[0].Alive)
+
+	var chains []*BlockedChain
+	assert.Equal(t, adminTestlibGet(r, "/admin/blocked", &chains), 200)
+	assert.Equal(t, len(chains), 1)
+
+	// disabled
+	assert.Equal(t, adminTestlibGet(commonTestlibExampleReplica(), "/admin/replica", nil), 404)
+}
+
+func TestNewInstanceStatus(t *testing.T) {
+	r := adminTestlibReplica()
+	i := adminTestlibAdd(r, 1, 2, accepted, message.Dependencies{0, 1, 0, 0, 0})
+	i.enterPreparing()
+
+	s := NewInstanceStatus(i.Pack())
+	assert.Equal(t, s.Status, "Preparing")
+	assert.Equal(t, s.Ballot, i.ballot.String())
+	assert.Equal(t, s.Cmds, []string{"a"})
+	assert.Equal(t, s.Recovery.FormerStatus, "Accepted")
+
+	assert.Equal(t, NewInstanceStatus(&PackedInstance{Status: 9}).Status, "Unknown(9)")
+}
@@ -0,0 +1,104 @@
+package replica
+
+// This file implements the adaptive batching of proposals.
+// A batch is proposed as soon as there is a free slot, i.e. less than
+// maxOutstanding instances of this replica are not committed yet. So under
+// low load every request is proposed alone, and under high load requests
+// are batched while waiting for a slot, up to the batch limits.
+// If forwarding is enabled and the quorum is lost, the instances in the
+// slots may never be committed, so the batches are forwarded without
+// waiting for a slot.
+
+import (
+	"github.com/go-distributed/epaxos/message"
+)
+
+// batchFull returns true if the requests reach a batch limit.
+func (r *Replica) batchFull(requests []*proposeRequest) bool {
+	count, size := 0, 0
+	for _, req := range requests {
+		for _, cmd := range req.cmds {
+			count++
+			size += len(cmd)
+		}
+	}
+	return count >= r.batchMaxCommands || size >= r.batchMaxBytes
+}
+
+// takeBatch removes the requests of the next batch from the buffer. The
+// batch has at least one request, and doesn't exceed the batch limits
+// otherwise.
+func (r *Replica) takeBatch(buffer *[]*proposeRequest) []*proposeRequest {
+	requests := *buffer
+	count, size := 0, 0
+	n := 0
+	for ; n < len(requests); n++ {
+		count += len(requests[n].cmds)
+		for _, cmd := range requests[n].cmds {
+			size += len(cmd)
+		}
+		if n > 0 && (count > r.batchMaxCommands || size > r.batchMaxBytes) {
+			break
+		}
+	}
+
+	batch := make([]*proposeRequest, n)
+	copy(batch, requests[:n])
+	*buffer = append(requests[:0], requests[n:]...)
+	return batch
+}
+
+func (r *Replica) proposeLoopAdaptive() {
+	bufferedRequests := make([]*proposeRequest, 0) // start from 0
+	for {
+		// stop receiving requests when the buffer is full
+		proposeChan := r.ProposeChan
+		if r.batchFull(bufferedRequests) {
+			proposeChan = nil
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case req := <-proposeChan:
+			bufferedRequests = append(bufferedRequests, req)
+		case <-r.slotFreed:
+		}
+
+		for len(bufferedRequests) > 0 {
+			if r.outstanding() < r.maxOutstanding {
+				batch := r.takeBatch(&bufferedRequests)
+				r.batchPropose(&batch)
+			} else if !r.forwardBatch(&bufferedRequests) {
+				break
+			}
+		}
+	}
+}
+
+// forwardBatch forwards the next batch if the quorum is lost. It returns
+// false if the batch is not forwarded, it's left in the buffer then.
+func (r *Replica) forwardBatch(buffer *[]*proposeRequest) bool {
+	if !r.enableForwarding || !r.QuorumLost() {
+		return false
+	}
+
+	batch := r.takeBatch(buffer)
+	cmds := make([]message.Command, 0)
+	forwarded := true
+	for _, req := range batch {
+		if req.noop {
+			forwarded = false
+			break
+		}
+		req.offset = len(cmds)
+		cmds = append(cmds, req.cmds...)
+	}
+	if forwarded {
+		forwarded = r.tryForward(cmds, batch)
+	}
+	if !forwarded {
+		*buffer = append(batch, *buffer...)
+	}
+	return forwarded
+}
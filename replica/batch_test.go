@@ -0,0 +1,155 @@
+package replica
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/test"
+	"github.com/go-distributed/epaxos/transporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func batchTestlibReplica(maxOutstanding int) *Replica {
+	r, err := New(&Param{
+		ReplicaId:              0,
+		Size:                   5,
+		StateMachine:           test.NewDummySM(),
+		Transporter:            transporter.NewDummyTR(0, 5),
+		EnableAdaptiveBatching: true,
+		BatchMaxCommands:       3,
+		BatchMaxBytes:          10,
+		MaxOutstanding:         maxOutstanding,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// batchTestlibWait waits for the instance to be created by the event loop,
+// and returns its commands and deps.
+func batchTestlibWait(t *testing.T, r *Replica, id uint64) (message.Commands, message.Dependencies) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		r.lock.Lock()
+		i := r.InstanceMatrix[r.Id][id]
+		if i != nil && i.isAtStatus(preAccepted) {
+			defer r.lock.Unlock()
+			return i.cmds.Clone(), i.deps.Clone()
+		}
+		r.lock.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("instance %d is not proposed", id)
+	return nil, nil
+}
+
+func batchTestlibRequests(cmds ...string) []*proposeRequest {
+	requests := make([]*proposeRequest, len(cmds))
+	for i := range cmds {
+		requests[i] = newProposeRequest(message.Command(cmds[i]))
+	}
+	return requests
+}
+
+// This func tests the limits of the batches.
+func TestTakeBatch(t *testing.T) {
+	r := batchTestlibReplica(1)
+
+	buffer := batchTestlibRequests("a", "b", "c", "d")
+	assert.True(t, r.batchFull(buffer))
+	batch := r.takeBatch(&buffer)
+	assert.Equal(t, len(batch), 3)
+	assert.Equal(t, len(buffer), 1)
+	assert.Equal(t, buffer[0].cmds, message.Commands{message.Command("d")})
+	assert.False(t, r.batchFull(buffer))
+
+	buffer = batchTestlibRequests("aaaaaa", "bbbbbb")
+	assert.True(t, r.batchFull(buffer))
+	assert.Equal(t, len(r.takeBatch(&buffer)), 1)
+
+	// a request over the limits is proposed alone
+	buffer = batchTestlibRequests("aaaaaaaaaaaa", "b")
+	assert.Equal(t, len(r.takeBatch(&buffer)), 1)
+	assert.Equal(t, len(r.takeBatch(&buffer)), 1)
+	assert.Equal(t, len(buffer), 0)
+}
+
+// Requests should be proposed alone while there are free slots, and
+// batched while waiting for one.
+func TestProposeLoopAdaptive(t *testing.T) {
+	r := batchTestlibReplica(1)
+	go r.receiveLoop()
+	go r.eventLoop()
+	go r.proposeLoop()
+	defer close(r.stop)
+
+	r.Propose(message.Command("a"))
+	cmds, deps := batchTestlibWait(t, r, 1)
+	assert.Equal(t, cmds, message.Commands{message.Command("a")})
+	assert.Equal(t, r.outstanding(), 1)
+
+	for _, c := range []string{"b", "c", "d", "e"} {
+		r.Propose(message.Command(c))
+	}
+	time.Sleep(10 * time.Millisecond)
+	r.lock.Lock()
+	assert.Nil(t, r.InstanceMatrix[0][2])
+	r.lock.Unlock()
+
+	// commit to free the slot
+	r.MessageChan <- &message.Commit{
+		ReplicaId:  0,
+		InstanceId: 1,
+		Cmds:       cmds,
+		Deps:       deps,
+		From:       1,
+	}
+	cmds, _ = batchTestlibWait(t, r, 2)
+	assert.Equal(t, cmds, message.Commands{
+		message.Command("b"),
+		message.Command("c"),
+		message.Command("d"),
+	})
+	assert.Equal(t, r.outstanding(), 1)
+}
+
+// Once the quorum is lost, the buffered requests should be forwarded
+// even if the slots are full.
+func TestProposeLoopAdaptiveForward(t *testing.T) {
+	r := batchTestlibReplica(1)
+	r.enableForwarding = true
+	go r.receiveLoop()
+	go r.eventLoop()
+	go r.proposeLoop()
+	defer close(r.stop)
+
+	r.Propose(message.Command("a"))
+	batchTestlibWait(t, r, 1)
+
+	go r.ProposeAndWait(message.Command("b"))
+	time.Sleep(10 * time.Millisecond)
+	r.forwardLock.Lock()
+	assert.Equal(t, len(r.pendingForwards), 0)
+	r.forwardLock.Unlock()
+
+	r.heardFrom(1)
+	r.setQuorumLost(true)
+	deadline := time.Now().Add(time.Second)
+	for {
+		r.forwardLock.Lock()
+		n := len(r.pendingForwards)
+		r.forwardLock.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the request is not forwarded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	r.lock.Lock()
+	assert.Nil(t, r.InstanceMatrix[0][2])
+	r.lock.Unlock()
+}
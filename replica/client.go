@@ -0,0 +1,83 @@
+package replica
+
+// This file implements the client api of the replica, served as json on
+// the http endpoint if EnableClientAPI is set:
+// - POST /client/propose: proposes the commands of a ClientRequest, and
+// - replies with their results once they are executed.
+// @decision(10/18/26):
+// - Commands are strings in json, the built-in state machines are text
+// - based. A command failing deterministically gets an error result, the
+// - other commands of the request are still executed.
+// - The proposal is not withdrawn if the client goes away, it may still
+// - be executed.
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-distributed/epaxos/message"
+)
+
+// the max size of a client request body
+const maxClientRequestBytes = 1 << 20
+
+// ClientRequest is the body of /client/propose.
+type ClientRequest struct {
+	Commands []string
+}
+
+// ClientResult is the result of a command, Error is set if it failed.
+type ClientResult struct {
+	Value interface{} `json:",omitempty"`
+	Error string      `json:",omitempty"`
+}
+
+// ClientResponse holds the results of the commands of a ClientRequest,
+// in the same order.
+type ClientResponse struct {
+	Results []ClientResult
+}
+
+func (r *Replica) initClientAPI() {
+	r.httpMux.HandleFunc("/client/propose", postOnly(r.serveClientPropose))
+}
+
+func (r *Replica) serveClientPropose(w http.ResponseWriter, req *http.Request) {
+	var creq ClientRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxClientRequestBytes))
+	if err := dec.Decode(&creq); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(creq.Commands) == 0 {
+		http.Error(w, "no command", http.StatusBadRequest)
+		return
+	}
+
+	cmds := make([]message.Command, len(creq.Commands))
+	for i := range creq.Commands {
+		cmds[i] = message.Command(creq.Commands[i])
+	}
+	results, err := r.proposeAndWait(req.Context().Done(), cmds...)
+	switch err {
+	case nil:
+	case ErrStopped:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case ErrCanceled:
+		return // the client is gone
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := &ClientResponse{Results: make([]ClientResult, len(results))}
+	for i, res := range results {
+		if e, ok := res.(error); ok {
+			resp.Results[i].Error = e.Error()
+		} else {
+			resp.Results[i].Value = res
+		}
+	}
+	writeJSON(w, resp)
+}
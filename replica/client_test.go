@@ -0,0 +1,54 @@
+package replica
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-distributed/epaxos/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func clientTestlibPropose(r *Replica, method, body string) (int, *ClientResponse) {
+	w := httptest.NewRecorder()
+	postOnly(r.serveClientPropose)(w, httptest.NewRequest(method, "/client/propose", strings.NewReader(body)))
+	if w.Code != 200 {
+		return w.Code, nil
+	}
+	resp := new(ClientResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		panic(err)
+	}
+	return w.Code, resp
+}
+
+func TestClientPropose(t *testing.T) {
+	nodes := forwardTestlibCluster(3)
+	defer forwardTestlibStop(nodes)
+
+	code, resp := clientTestlibPropose(nodes[0], "POST", `{"Commands": ["a", "invalid"]}`)
+	assert.Equal(t, code, 200)
+	assert.Equal(t, resp.Results, []ClientResult{
+		{Value: "a"},
+		{Error: test.ErrInvalidCommand.Error()},
+	})
+
+	code, _ = clientTestlibPropose(nodes[0], "GET", "")
+	assert.Equal(t, code, 405)
+	code, _ = clientTestlibPropose(nodes[0], "POST", `{"Commands": "a"}`)
+	assert.Equal(t, code, 400)
+	code, _ = clientTestlibPropose(nodes[0], "POST", `{"Commands": []}`)
+	assert.Equal(t, code, 400)
+}
+
+// The client api is only served if it's enabled.
+func TestClientAPIDisabled(t *testing.T) {
+	r := adminTestlibReplica()
+	assert.Equal(t, adminTestlibGet(r, "/client/propose", nil), 404)
+
+	r = commonTestlibExampleReplica()
+	r.enableClientAPI = true
+	r.initHTTP()
+	assert.Equal(t, adminTestlibGet(r, "/client/propose", nil), 405)
+}
@@ -0,0 +1,101 @@
+package replica
+
+// This file implements the conflict index.
+// With a keyed state machine, we keep for each instance space maps from key
+// to the highest instance reading or writing it, and to the highest instance
+// writing it. It replaces the backward scan in scanConflicts, whose cost grows
+// with the checkpoint cycle.
+// A read only conflicts with the latest write, so read-read pairs never
+// create dependencies. A write conflicts with the latest access of any mode.
+// Since every instance depends on its precessor in the same instance space,
+// depending on the latest access also orders the write after earlier reads.
+// @decision(10/18/26):
+// - An index entry is never removed when the commands of an instance change
+// - (e.g. recovered as no-op). A stale entry only adds a dependency, which is safe.
+// - Entries below the latest checkpoint are dropped, because the checkpoint
+// - conflicts with everything anyway.
+
+import (
+	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/message"
+)
+
+type conflictIndex struct {
+	sm    epaxos.KeyedStateMachine
+	cycle uint64
+	rows  []*conflictIndexRow
+}
+
+type conflictIndexRow struct {
+	accesses map[string]uint64 // reads and writes
+	writes   map[string]uint64
+	floor    uint64 // the checkpoint below all indexed instances
+}
+
+func newConflictIndexRow(floor uint64) *conflictIndexRow {
+	return &conflictIndexRow{
+		accesses: make(map[string]uint64),
+		writes:   make(map[string]uint64),
+		floor:    floor,
+	}
+}
+
+func newConflictIndex(sm epaxos.KeyedStateMachine, size uint8, cycle uint64) *conflictIndex {
+	c := &conflictIndex{
+		sm:    sm,
+		cycle: cycle,
+		rows:  make([]*conflictIndexRow, size),
+	}
+	for i := range c.rows {
+		c.rows[i] = newConflictIndexRow(0)
+	}
+	return c
+}
+
+// add indexes the commands of instance [rowId][id].
+func (c *conflictIndex) add(rowId uint8, id uint64, cmds message.Commands) {
+	row := c.rows[rowId]
+	floor := id - id%c.cycle
+	if floor < row.floor {
+		return
+	}
+	if floor > row.floor {
+		row = newConflictIndexRow(floor)
+		c.rows[rowId] = row
+	}
+
+	for _, cmd := range cmds {
+		for _, k := range c.sm.ConflictKeys(cmd) {
+			if row.accesses[k.Key] < id {
+				row.accesses[k.Key] = id
+			}
+			if k.Mode == epaxos.WriteAccess && row.writes[k.Key] < id {
+				row.writes[k.Key] = id
+			}
+		}
+	}
+}
+
+// latest returns the highest instance in (end, start] of the instance space
+// that conflicts with cmds, or end if there is none.
+// As in scanConflicts, a checkpoint conflicts with everything.
+func (c *conflictIndex) latest(rowId uint8, cmds message.Commands, start uint64, end uint64) uint64 {
+	conflict := end
+	if cp := start - start%c.cycle; cp > conflict {
+		conflict = cp
+	}
+
+	row := c.rows[rowId]
+	for _, cmd := range cmds {
+		for _, k := range c.sm.ConflictKeys(cmd) {
+			keys := row.writes
+			if k.Mode == epaxos.WriteAccess {
+				keys = row.accesses
+			}
+			if id := keys[k.Key]; id > conflict && id <= start {
+				conflict = id
+			}
+		}
+	}
+	return conflict
+}
@@ -0,0 +1,227 @@
+package replica
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/test"
+	"github.com/go-distributed/epaxos/transporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func conflictIndexTestlibReplica() *Replica {
+	param := &Param{
+		ReplicaId:       0,
+		Size:            5,
+		CheckpointCycle: 16,
+		StateMachine:    test.NewDummyKVSM(),
+		Transporter:     transporter.NewDummyTR(0, 5),
+	}
+	r, err := New(param)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func TestConflictIndexLatest(t *testing.T) {
+	r := conflictIndexTestlibReplica()
+	c := r.conflictIndex
+	assert.NotNil(t, c)
+
+	c.add(1, 3, message.Commands{message.Command("put a 1")})
+	c.add(1, 5, message.Commands{message.Command("put b 1")})
+	c.add(1, 7, message.Commands{message.Command("get a")})
+
+	a := message.Commands{message.Command("put a 2")}
+	b := message.Commands{message.Command("get b")}
+	x := message.Commands{message.Command("put x 1")}
+
+	assert.Equal(t, c.latest(1, a, 10, 0), uint64(7))
+	assert.Equal(t, c.latest(1, b, 10, 0), uint64(5))
+	// a read only depends on the latest write
+	assert.Equal(t, c.latest(1, message.Commands{message.Command("get a")}, 10, 0), uint64(3))
+	assert.Equal(t, c.latest(1, b, 10, 5), uint64(5))
+	assert.Equal(t, c.latest(1, x, 10, 0), uint64(0))
+	assert.Equal(t, c.latest(1, x, 10, 2), uint64(2))
+
+	// a checkpoint conflicts with everything
+	assert.Equal(t, c.latest(1, x, 20, 0), uint64(16))
+	assert.Equal(t, c.latest(1, a, 20, 0), uint64(16))
+
+	// crossing a checkpoint drops older entries
+	c.add(1, 18, message.Commands{message.Command("put b 2")})
+	assert.Equal(t, c.latest(1, a, 20, 0), uint64(16))
+	assert.Equal(t, c.latest(1, b, 20, 0), uint64(18))
+
+	// instances behind the checkpoint are not indexed
+	c.add(1, 9, message.Commands{message.Command("put x 2")})
+	assert.Equal(t, c.latest(1, x, 20, 0), uint64(16))
+}
+
+// The index should always find the same conflicts as the scan,
+// starting from MaxInstanceNum as initInstance and updateInstance do.
+func TestConflictIndexSameAsScan(t *testing.T) {
+	r := conflictIndexTestlibReplica()
+	rnd := rand.New(rand.NewSource(1))
+
+	randomCmds := func() message.Commands {
+		cmds := make(message.Commands, 1+rnd.Intn(2))
+		for i := range cmds {
+			op := "put"
+			if rnd.Intn(2) == 0 {
+				op = "get"
+			}
+			cmds[i] = message.Command(fmt.Sprintf("%s k%d 0", op, rnd.Intn(8)))
+		}
+		return cmds
+	}
+
+	for id := uint64(1); id <= 60; id++ {
+		for row := uint8(1); row < r.Size; row++ {
+			r.MaxInstanceNum[row] = id
+			if r.IsCheckpoint(id) || rnd.Intn(4) == 0 {
+				continue // leave some holes
+			}
+			inst := NewInstance(r, row, id)
+			inst.cmds = randomCmds()
+			r.InstanceMatrix[row][id] = inst
+			r.indexInstance(inst)
+		}
+
+		for n := 0; n < 10; n++ {
+			row := uint8(1 + rnd.Intn(int(r.Size)-1))
+			cmds := randomCmds()
+			end := uint64(rnd.Intn(int(id) + 2))
+			assert.Equal(t,
+				r.findConflict(row, cmds, id, end),
+				r.scanConflicts(r.InstanceMatrix[row], cmds, id, end))
+		}
+	}
+}
+
+// initInstance should only depend on instances touching the same keys.
+func TestInitInstanceWithKeyedSM(t *testing.T) {
+	r := conflictIndexTestlibReplica()
+	for row := uint8(1); row < r.Size; row++ {
+		for id := uint64(1); id <= 3; id++ {
+			inst := NewInstance(r, row, id)
+			inst.cmds = message.Commands{
+				message.Command(fmt.Sprintf("put k%d-%d 0", row, id)),
+			}
+			r.InstanceMatrix[row][id] = inst
+			r.indexInstance(inst)
+		}
+		r.MaxInstanceNum[row] = 3
+	}
+
+	i := NewInstance(r, r.Id, 1)
+	r.initInstance(message.Commands{
+		message.Command("put k1-2 1"),
+		message.Command("get k3-3"),
+	}, i)
+	assert.Equal(t, i.deps, message.Dependencies{0, 2, 0, 3, 0})
+}
+
+// crossedProposals lets replica 0 and 1 propose cmds concurrently, each
+// pre-accepting the other's instance first, which is the worst case for
+// dependencies. It returns the sizes of the sccs found on replica 0.
+func crossedProposals(sm epaxos.StateMachine, cmds message.Commands) []int {
+	replicas := make([]*Replica, 2)
+	for id := range replicas {
+		r, err := New(&Param{
+			ReplicaId:    uint8(id),
+			Size:         3,
+			StateMachine: sm,
+			Transporter:  transporter.NewDummyTR(uint8(id), 3),
+		})
+		if err != nil {
+			panic(err)
+		}
+		replicas[id] = r
+	}
+
+	// each replica pre-accepts the other's proposal, then proposes its own.
+	for id, r := range replicas {
+		other := uint8(1 - id)
+		r.updateMaxInstanceNum(other, 1)
+		r.InstanceMatrix[other][1] = NewInstance(r, other, 1)
+		r.updateInstance(cmds, r.makeInitialDeps(), other, r.InstanceMatrix[other][1])
+
+		r.updateMaxInstanceNum(r.Id, 1)
+		r.InstanceMatrix[r.Id][1] = NewInstance(r, r.Id, 1)
+		r.initInstance(cmds, r.InstanceMatrix[r.Id][1])
+	}
+
+	// commit both instances on replica 0 with the deps of their leaders.
+	r := replicas[0]
+	r.InstanceMatrix[1][1].deps = replicas[1].InstanceMatrix[1][1].deps.Clone()
+	for row := 0; row < 2; row++ {
+		r.InstanceMatrix[row][1].status = committed
+	}
+
+	sizes := make([]int, 0)
+	for row := 0; row < 2; row++ {
+		inst := r.InstanceMatrix[row][1]
+		if inst.sccIndex != 0 {
+			continue // already resolved
+		}
+		r.sccStack = r.sccStack[:0]
+		r.sccResults = make([][]*Instance, 0)
+		r.sccIndex = 1
+		r.resolveConflicts(inst)
+		for _, scc := range r.sccResults {
+			sizes = append(sizes, len(scc))
+		}
+	}
+	return sizes
+}
+
+// Concurrent reads on the same key should not depend on each other,
+// while concurrent writes end up in one scc.
+func TestReadReadNoDependency(t *testing.T) {
+	reads := message.Commands{message.Command("get k")}
+	writes := message.Commands{message.Command("put k 1")}
+
+	// identical commands always conflict in DummySM.
+	assert.Equal(t, crossedProposals(test.NewDummySM(), reads), []int{2})
+
+	assert.Equal(t, crossedProposals(test.NewDummyKVSM(), writes), []int{2})
+	assert.Equal(t, crossedProposals(test.NewDummyKVSM(), reads), []int{1, 1})
+}
+
+// A read-heavy workload (one write, then only reads) should have fewer
+// dependencies than the same workload where every access is a write.
+func TestReadHeavyFewerDependencies(t *testing.T) {
+	count := func(writeOnly bool) int {
+		r := conflictIndexTestlibReplica()
+		deps := 0
+		for id := uint64(1); id < 16; id++ {
+			for row := uint8(0); row < r.Size; row++ {
+				op := "get"
+				if writeOnly || (row == 1 && id == 1) {
+					op = "put"
+				}
+				cmds := message.Commands{message.Command(op + " k 0")}
+				inst := NewInstance(r, row, id)
+				r.updateMaxInstanceNum(row, id)
+				if row == r.Id {
+					r.initInstance(cmds, inst)
+				} else {
+					r.updateInstance(cmds, r.makeInitialDeps(), row, inst)
+				}
+				r.InstanceMatrix[row][id] = inst
+				for d := range inst.deps {
+					if uint8(d) != row && inst.deps[d] != conflictNotFound {
+						deps++
+					}
+				}
+			}
+		}
+		return deps
+	}
+	assert.True(t, count(false) < count(true)/2)
+}
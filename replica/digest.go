@@ -0,0 +1,237 @@
+package replica
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+
+	"github.com/go-distributed/epaxos/logger"
+	"github.com/go-distributed/epaxos/message"
+)
+
+// the max number of hashes of one instance space in a digest message
+const maxDigestHashes = 64
+
+// Divergence describes the first instance executed differently
+// on this replica and a peer.
+type Divergence struct {
+	Peer       uint8
+	RowId      uint8
+	InstanceId uint64
+	Local      uint64
+	Remote     uint64
+}
+
+func (d *Divergence) String() string {
+	return fmt.Sprintf("Instance[%v][%v] diverged from Replica[%v], hash %x != %x",
+		d.RowId, d.InstanceId, d.Peer, d.Local, d.Remote)
+}
+
+// instanceHash folds an executed instance into the rolling hash of its
+// instance space: the sequence of the scc it was executed in, its commands
+// and their results. The executor runs an scc as one batch, in the same
+// order on every replica, while sccs which don't depend on each other may
+// be executed in any order, so the rows are still folded in id order.
+func instanceHash(prev uint64, i *Instance) uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+	writeUint := func(v uint64) {
+		binary.BigEndian.PutUint64(buf, v)
+		h.Write(buf)
+	}
+
+	writeUint(prev)
+	writeUint(uint64(i.rowId))
+	writeUint(i.id)
+	writeUint(uint64(len(i.executedWith)))
+	for _, ref := range i.executedWith {
+		writeUint(uint64(ref.rowId))
+		writeUint(ref.id)
+	}
+	writeUint(uint64(len(i.cmds)))
+	for _, cmd := range i.cmds {
+		writeUint(uint64(len(cmd)))
+		h.Write(cmd)
+	}
+	writeUint(uint64(len(i.results)))
+	for _, res := range i.results {
+		hashResult(h, res)
+	}
+
+	sum := h.Sum64()
+	if sum == 0 { // 0 means no hash
+		sum = 1
+	}
+	return sum
+}
+
+// hashResult writes a result with its type, encoded the same way on every
+// replica. Values which are not basic types are encoded as json, which
+// follows pointers and sorts map keys.
+func hashResult(h hash.Hash, res interface{}) {
+	buf := make([]byte, 8)
+	write := func(kind byte, v uint64, b []byte) {
+		h.Write([]byte{kind})
+		binary.BigEndian.PutUint64(buf, v)
+		h.Write(buf)
+		h.Write(b)
+	}
+
+	switch v := res.(type) {
+	case nil:
+		write('n', 0, nil)
+	case string:
+		write('s', uint64(len(v)), []byte(v))
+	case []byte:
+		write('b', uint64(len(v)), v)
+	case bool:
+		if v {
+			write('t', 1, nil)
+		} else {
+			write('t', 0, nil)
+		}
+	case int:
+		write('i', uint64(v), nil)
+	case int64:
+		write('i', uint64(v), nil)
+	case uint64:
+		write('u', v, nil)
+	case float64:
+		write('f', math.Float64bits(v), nil)
+	case error:
+		msg := v.Error()
+		write('e', uint64(len(msg)), []byte(msg))
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			b = []byte(fmt.Sprintf("%T", v))
+		}
+		write('j', uint64(len(b)), b)
+	}
+}
+
+// updateDigest folds the instances executed in instance space row, in the
+// order of their ids. The rolling hash restarts at every checkpoint, so
+// after restoring, the hashes are comparable again from the next one.
+func (r *Replica) updateDigest(row int) {
+	r.digestLock.Lock()
+	defer r.digestLock.Unlock()
+
+	for r.hashedUpTo[row] < r.ExecutedUpTo[row] {
+		id := r.hashedUpTo[row] + 1
+		r.hashedUpTo[row] = id
+		if r.IsCheckpoint(id) {
+			r.rowHash[row] = 0
+			r.rowHashValid[row] = true
+			continue
+		}
+		if !r.rowHashValid[row] {
+			continue
+		}
+		instance := r.InstanceMatrix[row][id]
+		r.rowHash[row] = instanceHash(r.rowHash[row], instance)
+		instance.execHash = r.rowHash[row]
+	}
+}
+
+// makeDigest returns the hashes of instances executed since the last
+// digest, or nil if there are none.
+func (r *Replica) makeDigest() *message.Digest {
+	r.digestLock.Lock()
+	defer r.digestLock.Unlock()
+
+	d := &message.Digest{From: r.Id}
+	for row := range r.InstanceMatrix {
+		start := r.digestSentUpTo[row] + 1
+		end := r.hashedUpTo[row]
+		if end < start {
+			continue
+		}
+		if end-start >= maxDigestHashes {
+			end = start + maxDigestHashes - 1
+		}
+
+		hashes := make([]uint64, 0, end-start+1)
+		for id := start; id <= end; id++ {
+			var h uint64
+			if instance := r.InstanceMatrix[row][id]; instance != nil {
+				h = instance.execHash
+			}
+			hashes = append(hashes, h)
+		}
+		r.digestSentUpTo[row] = end
+		d.Rows = append(d.Rows, message.RowDigest{
+			RowId:  uint8(row),
+			Start:  start,
+			Hashes: hashes,
+		})
+	}
+	if len(d.Rows) == 0 {
+		return nil
+	}
+	return d
+}
+
+// handleDigest compares the hashes of a peer with the local ones. Hashes
+// of instances not executed here yet are skipped, they are checked by the
+// peer when it receives the digest of this replica.
+func (r *Replica) handleDigest(d *message.Digest) {
+	r.digestLock.Lock()
+	defer r.digestLock.Unlock()
+
+	if r.divergence != nil {
+		return // only the first one is kept
+	}
+
+	for _, row := range d.Rows {
+		for k, remote := range row.Hashes {
+			id := row.Start + uint64(k)
+			if remote == 0 || id > r.hashedUpTo[row.RowId] {
+				continue
+			}
+			instance := r.InstanceMatrix[row.RowId][id]
+			if instance == nil || instance.execHash == 0 {
+				continue
+			}
+			if instance.execHash != remote {
+				r.divergence = &Divergence{
+					Peer:       d.From,
+					RowId:      row.RowId,
+					InstanceId: id,
+					Local:      instance.execHash,
+					Remote:     remote,
+				}
+				r.logger.Error("execution diverged", logger.Fields{
+					"peer":     d.From,
+					"row":      row.RowId,
+					"instance": id,
+				})
+				return
+			}
+		}
+	}
+}
+
+// Divergence returns the first instance found executed differently
+// on this replica and a peer, or nil.
+func (r *Replica) Divergence() *Divergence {
+	r.digestLock.Lock()
+	defer r.digestLock.Unlock()
+	return r.divergence
+}
+
+func (r *Replica) digestLoop() {
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-r.digestTicker.C:
+			if d := r.makeDigest(); d != nil {
+				r.Transporter.Broadcast(d)
+			}
+		}
+	}
+}
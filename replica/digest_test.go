@@ -0,0 +1,112 @@
+package replica
+
+import (
+	"testing"
+
+	"github.com/go-distributed/epaxos/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func digestTestlibReplica(id uint8, perRow uint64) *Replica {
+	r := makeChainReplica(perRow, false)
+	r.Id = id
+	r.enableDigest = true
+	return r
+}
+
+// Replicas executing the same commands should have the same hashes.
+func TestDigestSame(t *testing.T) {
+	a := digestTestlibReplica(0, 3)
+	b := digestTestlibReplica(1, 3)
+	a.findAndExecute()
+	b.findAndExecute()
+
+	assert.Equal(t, a.hashedUpTo, []uint64{3, 3, 3, 3, 3})
+	assert.Equal(t, a.rowHash, b.rowHash)
+
+	d := b.makeDigest()
+	assert.Equal(t, len(d.Rows), 5)
+	assert.Equal(t, d.Rows[2], message.RowDigest{
+		RowId:  2,
+		Start:  1,
+		Hashes: []uint64{b.InstanceMatrix[2][1].execHash, b.InstanceMatrix[2][2].execHash, b.InstanceMatrix[2][3].execHash},
+	})
+	// hashes are only sent once
+	assert.Nil(t, b.makeDigest())
+
+	a.handleDigest(d)
+	assert.Nil(t, a.Divergence())
+}
+
+// The first instance executed differently should be reported.
+func TestDigestDivergence(t *testing.T) {
+	a := digestTestlibReplica(0, 3)
+	b := digestTestlibReplica(1, 3)
+	b.InstanceMatrix[2][2].cmds = message.Commands{message.Command("x")}
+	a.findAndExecute()
+	b.findAndExecute()
+
+	// rows before are the same
+	assert.Equal(t, a.InstanceMatrix[2][1].execHash, b.InstanceMatrix[2][1].execHash)
+	// and the rolling hash differs after the divergence
+	assert.NotEqual(t, a.InstanceMatrix[2][3].execHash, b.InstanceMatrix[2][3].execHash)
+
+	a.handleDigest(b.makeDigest())
+	div := a.Divergence()
+	assert.NotNil(t, div)
+	assert.Equal(t, div.Peer, uint8(1))
+	assert.Equal(t, div.RowId, uint8(2))
+	assert.Equal(t, div.InstanceId, uint64(2))
+	assert.Equal(t, div.Local, a.InstanceMatrix[2][2].execHash)
+	assert.Equal(t, div.Remote, b.InstanceMatrix[2][2].execHash)
+}
+
+// Hashes of instances a replica hasn't executed are checked by the peer.
+func TestDigestBehind(t *testing.T) {
+	a := digestTestlibReplica(0, 3)
+	b := digestTestlibReplica(1, 3)
+	b.InstanceMatrix[2][2].cmds = message.Commands{message.Command("x")}
+	b.findAndExecute()
+
+	// a is behind, and can't check the hashes of b
+	a.handleDigest(b.makeDigest())
+	assert.Nil(t, a.Divergence())
+
+	a.findAndExecute()
+	b.handleDigest(a.makeDigest())
+	div := b.Divergence()
+	assert.NotNil(t, div)
+	assert.Equal(t, div.Peer, uint8(0))
+	assert.Equal(t, div.InstanceId, uint64(2))
+}
+
+// The rolling hash restarts at every checkpoint, and only
+// the instances after it are hashed after restoring.
+func TestDigestCheckpoint(t *testing.T) {
+	r := digestTestlibReplica(0, 3)
+	r.CheckpointCycle = 2
+	r.InstanceMatrix[0][2] = nil
+	r.rowHashValid[0] = false
+	r.ExecutedUpTo[0] = 3
+	r.updateDigest(0)
+
+	assert.Equal(t, r.InstanceMatrix[0][1].execHash, uint64(0))
+	assert.Equal(t, r.InstanceMatrix[0][3].execHash, instanceHash(0, r.InstanceMatrix[0][3]))
+}
+
+// Results should be hashed by value, and the order of the scc an
+// instance was executed in is part of its hash.
+func TestInstanceHash(t *testing.T) {
+	type value struct{ N int }
+	a := &Instance{rowId: 1, id: 2, results: []interface{}{&value{1}, "x"}}
+	b := &Instance{rowId: 1, id: 2, results: []interface{}{&value{1}, "x"}}
+	assert.Equal(t, instanceHash(0, a), instanceHash(0, b))
+
+	b.results[1] = []byte("x")
+	assert.NotEqual(t, instanceHash(0, a), instanceHash(0, b))
+	b.results[1] = "x"
+
+	a.executedWith = []instanceRef{{1, 2}, {3, 1}}
+	b.executedWith = []instanceRef{{3, 1}, {1, 2}}
+	assert.NotEqual(t, instanceHash(0, a), instanceHash(0, b))
+}
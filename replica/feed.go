@@ -0,0 +1,341 @@
+package replica
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/logger"
+	"github.com/go-distributed/epaxos/message"
+)
+
+var (
+	ErrFeedDisabled            = errors.New("Execution feed is disabled")
+	ErrFeedPositionUnavailable = errors.New("Execution feed position unavailable")
+)
+
+const (
+	subscriptionBuffer = 64
+	// the number of batches kept in the store if no retention is set
+	defaultFeedRetention = 1 << 16
+)
+
+func init() {
+	gob.Register(ResultError(""))
+}
+
+// ExecutedInstance is an instance in an executed batch.
+type ExecutedInstance struct {
+	RowId    uint8
+	Id       uint64
+	Commands message.Commands
+	Results  []interface{}
+}
+
+// ExecutedBatch is an scc executed by the state machine as one batch.
+// Seq is the position of the batch in the execution feed, starting from 1.
+type ExecutedBatch struct {
+	Seq       uint64
+	Instances []ExecutedInstance
+}
+
+// ResultError replaces error results of the batches read back
+// from the persistent store.
+type ResultError string
+
+func (e ResultError) Error() string {
+	return string(e)
+}
+
+// Subscription delivers executed batches on C in the order of Seq.
+// The execution waits for subscribers which don't keep up.
+// C is closed when the subscription or the replica is closed, or when
+// a stored batch can't be loaded, see Err.
+type Subscription struct {
+	C <-chan *ExecutedBatch
+
+	c       chan *ExecutedBatch
+	live    chan *ExecutedBatch
+	from    uint64 // the first batch, loaded from the store if before handoff
+	handoff uint64 // the first batch published after subscribing
+	load    func(seq uint64) (*ExecutedBatch, error)
+	err     error
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newSubscription(from, handoff uint64, load func(uint64) (*ExecutedBatch, error)) *Subscription {
+	c := make(chan *ExecutedBatch)
+	s := &Subscription{
+		C:       c,
+		c:       c,
+		live:    make(chan *ExecutedBatch, subscriptionBuffer),
+		from:    from,
+		handoff: handoff,
+		load:    load,
+		done:    make(chan struct{}),
+	}
+	go s.forward()
+	return s
+}
+
+// Close stops the delivery of batches.
+func (s *Subscription) Close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// Err returns the error which closed C, e.g. ErrFeedPositionUnavailable
+// if stored batches were removed by the retention before being delivered.
+// It's nil if the subscription or the replica was closed. It must only
+// be called once C is closed.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// forward delivers the stored batches before handoff, loaded one by one
+// while new batches wait in live, then the live ones.
+func (s *Subscription) forward() {
+	defer close(s.c)
+	for seq := s.from; seq < s.handoff; seq++ {
+		b, err := s.load(seq)
+		if err == epaxos.ErrorNotFound {
+			err = ErrFeedPositionUnavailable
+		}
+		if err != nil {
+			s.err = err
+			s.Close()
+			return
+		}
+		select {
+		case s.c <- b:
+		case <-s.done:
+			return
+		}
+	}
+	for {
+		select {
+		case b := <-s.live:
+			select {
+			case s.c <- b:
+			case <-s.done:
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// executionFeed numbers the executed batches and publishes them to the
+// subscriptions. If store is not nil, the last retention batches are also
+// kept in it, so a subscription can start from an earlier position.
+type executionFeed struct {
+	mu        sync.Mutex
+	replicaId uint8
+	store     epaxos.Persistent
+	retention uint64
+	next      uint64 // seq of the next batch
+	subs      map[*Subscription]bool
+	logger    logger.Logger
+	done      chan struct{} // closed by close, so publish stops waiting
+	once      sync.Once
+}
+
+func newExecutionFeed(replicaId uint8, store epaxos.Persistent, retention uint64, log logger.Logger) *executionFeed {
+	if retention == 0 {
+		retention = defaultFeedRetention
+	}
+	return &executionFeed{
+		replicaId: replicaId,
+		store:     store,
+		retention: retention,
+		logger:    log,
+		next:      1,
+		subs:      make(map[*Subscription]bool),
+		done:      make(chan struct{}),
+	}
+}
+
+// oldest returns the seq of the oldest batch kept in the store.
+func (f *executionFeed) oldest() uint64 {
+	if f.next > f.retention {
+		return f.next - f.retention
+	}
+	return 1
+}
+
+func (f *executionFeed) batchKey(seq uint64) string {
+	return fmt.Sprintf("%v-feed-%v", f.replicaId, seq)
+}
+
+func (f *executionFeed) nextKey() string {
+	return fmt.Sprintf("%v-feed", f.replicaId)
+}
+
+// restore loads the position of the feed from the store.
+func (f *executionFeed) restore() error {
+	b, err := f.store.Get(f.nextKey())
+	if err == epaxos.ErrorNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewBuffer(b)).Decode(&f.next)
+}
+
+// publish numbers the batch and sends it to all subscriptions,
+// it blocks until every subscription has room for it, or the
+// feed is closed.
+func (f *executionFeed) publish(instances []ExecutedInstance) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b := &ExecutedBatch{
+		Seq:       f.next,
+		Instances: instances,
+	}
+	f.next++
+
+	if f.store != nil {
+		if err := f.storeBatch(b); err != nil {
+			f.logger.Error("failed to store executed batch", logger.Fields{
+				"seq": b.Seq,
+				"err": err,
+			})
+		}
+	}
+
+	for s := range f.subs {
+		select {
+		case s.live <- b:
+		case <-s.done:
+			delete(f.subs, s)
+		case <-f.done:
+			return
+		}
+	}
+}
+
+func (f *executionFeed) storeBatch(b *ExecutedBatch) error {
+	var batch, next bytes.Buffer
+
+	if err := gob.NewEncoder(&batch).Encode(storedBatch(b)); err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(&next).Encode(f.next); err != nil {
+		return err
+	}
+	err := f.store.BatchPut([]*epaxos.KVpair{
+		{Key: f.batchKey(b.Seq), Value: batch.Bytes()},
+		{Key: f.nextKey(), Value: next.Bytes()},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the batch out of the retention
+	if b.Seq > f.retention {
+		err = f.store.Delete(f.batchKey(b.Seq - f.retention))
+		if err == epaxos.ErrorNotFound {
+			err = nil
+		}
+	}
+	return err
+}
+
+// storedBatch returns a copy of the batch with error results
+// replaced by ResultError, which can be encoded.
+func storedBatch(b *ExecutedBatch) *ExecutedBatch {
+	s := &ExecutedBatch{
+		Seq:       b.Seq,
+		Instances: make([]ExecutedInstance, len(b.Instances)),
+	}
+	for i, inst := range b.Instances {
+		s.Instances[i] = inst
+		s.Instances[i].Results = encodableResults(inst.Results)
+	}
+	return s
+}
+
+func (f *executionFeed) loadBatch(seq uint64) (*ExecutedBatch, error) {
+	v, err := f.store.Get(f.batchKey(seq))
+	if err != nil {
+		return nil, err
+	}
+	b := new(ExecutedBatch)
+	if err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// subscribe returns a subscription starting from the batch at seq from,
+// or from the next batch if from is 0. The batches before the next one
+// are loaded from the store by the subscription, without the lock, so
+// they don't hold up publishing.
+func (f *executionFeed) subscribe(from uint64) (*Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return nil, ErrStopped
+	default:
+	}
+	if from == 0 {
+		from = f.next
+	}
+	if from > f.next {
+		return nil, ErrFeedPositionUnavailable
+	}
+	if from < f.next && (f.store == nil || from < f.oldest()) {
+		return nil, ErrFeedPositionUnavailable
+	}
+
+	s := newSubscription(from, f.next, f.loadBatch)
+	f.subs[s] = true
+	return s, nil
+}
+
+// close closes all subscriptions. It doesn't wait for the lock
+// before stopping publish, which holds it while a subscriber
+// doesn't keep up.
+func (f *executionFeed) close() {
+	f.once.Do(func() { close(f.done) })
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for s := range f.subs {
+		s.Close()
+		delete(f.subs, s)
+	}
+}
+
+// Subscribe returns a subscription to the executed batches, starting from
+// the batch at position from, or from the next executed batch if from is 0.
+// Earlier positions are only available if the replica is persistent.
+// The instances executed again after a restart, to rebuild the state of
+// the state machine, are not published again.
+func (r *Replica) Subscribe(from uint64) (*Subscription, error) {
+	if r.feed == nil {
+		return nil, ErrFeedDisabled
+	}
+	return r.feed.subscribe(from)
+}
+
+// publishExecuted sends the executed instances to the execution feed.
+func (r *Replica) publishExecuted(published []*Instance) {
+	instances := make([]ExecutedInstance, len(published))
+	for i, inst := range published {
+		instances[i] = ExecutedInstance{
+			RowId:    inst.rowId,
+			Id:       inst.id,
+			Commands: inst.cmds,
+			Results:  inst.results,
+		}
+	}
+	r.feed.publish(instances)
+}
@@ -0,0 +1,210 @@
+package replica
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/test"
+	"github.com/go-distributed/epaxos/transporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func feedTestlibReplica(persistent bool, restore bool) *Replica {
+	r, err := New(&Param{
+		ReplicaId:           0,
+		Size:                5,
+		CheckpointCycle:     1 << 20,
+		StateMachine:        test.NewDummySM(),
+		Transporter:         transporter.NewDummyTR(0, 5),
+		EnablePersistent:    persistent,
+		Restore:             restore,
+		PersistentPath:      os.TempDir() + "/epaxos-feed-test",
+		EnableExecutionFeed: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func feedTestlibReceive(t *testing.T, s *Subscription, n int) []*ExecutedBatch {
+	batches := make([]*ExecutedBatch, 0, n)
+	for len(batches) < n {
+		select {
+		case b := <-s.C:
+			batches = append(batches, b)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d batches, expected %d", len(batches), n)
+		}
+	}
+	return batches
+}
+
+func TestSubscribeDisabled(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	_, err := r.Subscribe(0)
+	assert.Equal(t, err, ErrFeedDisabled)
+}
+
+// Subscribers should receive every executed scc in order.
+func TestSubscribe(t *testing.T) {
+	r := feedTestlibReplica(false, false)
+	makeChain(r, 2, false)
+	r.InstanceMatrix[2][1].cmds = message.Commands{message.Command("invalid")}
+
+	s, err := r.Subscribe(0)
+	assert.NoError(t, err)
+	go r.findAndExecute()
+
+	batches := feedTestlibReceive(t, s, 10)
+	for n, b := range batches {
+		assert.Equal(t, b.Seq, uint64(n+1))
+		assert.Equal(t, len(b.Instances), 1)
+	}
+	assert.Equal(t, batches[0].Instances[0], ExecutedInstance{
+		RowId:    4,
+		Id:       2,
+		Commands: message.Commands{message.Command("4-2")},
+		Results:  []interface{}{"4-2"},
+	})
+	assert.Equal(t, batches[7].Instances[0].Results, []interface{}{test.ErrInvalidCommand})
+	assert.Equal(t, batches[9].Instances[0].RowId, uint8(0))
+	assert.Equal(t, batches[9].Instances[0].Id, uint64(1))
+
+	// earlier positions are not kept without persistence
+	_, err = r.Subscribe(1)
+	assert.Equal(t, err, ErrFeedPositionUnavailable)
+	_, err = r.Subscribe(12)
+	assert.Equal(t, err, ErrFeedPositionUnavailable)
+
+	s.Close()
+	_, ok := <-s.C
+	assert.False(t, ok)
+}
+
+// The execution should wait for subscribers which don't keep up.
+func TestSubscribeBackpressure(t *testing.T) {
+	r := feedTestlibReplica(false, false)
+	makeChain(r, 20, false)
+
+	s, err := r.Subscribe(0)
+	assert.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		r.findAndExecute()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("execution should wait for the subscriber")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.False(t, r.InstanceMatrix[0][1].isExecuted())
+
+	feedTestlibReceive(t, s, 100)
+	<-done
+	assert.True(t, r.InstanceMatrix[0][1].isExecuted())
+}
+
+// Stopping the replica should not wait for subscribers which don't keep up.
+func TestSubscribeStopStalled(t *testing.T) {
+	r := feedTestlibReplica(false, false)
+	makeChain(r, 20, false)
+
+	s, err := r.Subscribe(0)
+	assert.NoError(t, err)
+	go r.findAndExecute()
+	time.Sleep(100 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		r.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stop should not wait for the subscriber")
+	}
+	_, err = r.Subscribe(0)
+	assert.Equal(t, err, ErrStopped)
+	// the subscription is closed
+	for range s.C {
+	}
+}
+
+// With persistence, a subscription can resume from an earlier position,
+// also after a restart.
+func TestSubscribeResume(t *testing.T) {
+	r := feedTestlibReplica(true, false)
+	defer r.store.Drop()
+	makeChain(r, 2, false)
+	r.InstanceMatrix[2][1].cmds = message.Commands{message.Command("invalid")}
+	assert.NoError(t, r.StoreReplica())
+
+	r.findAndExecute()
+	assert.True(t, r.InstanceMatrix[0][1].isExecuted())
+
+	s, err := r.Subscribe(4)
+	assert.NoError(t, err)
+	batches := feedTestlibReceive(t, s, 7)
+	for n, b := range batches {
+		assert.Equal(t, b.Seq, uint64(n+4))
+	}
+	assert.Equal(t, batches[4].Instances[0].Results,
+		[]interface{}{ResultError(test.ErrInvalidCommand.Error())})
+	assert.Equal(t, batches[6].Instances[0].Commands,
+		message.Commands{message.Command("0-1")})
+	s.Close()
+
+	// restart
+	r.store.Close()
+	rr := feedTestlibReplica(true, true)
+	assert.Equal(t, rr.feed.next, uint64(11))
+
+	s, err = rr.Subscribe(10)
+	assert.NoError(t, err)
+	batches = feedTestlibReceive(t, s, 1)
+	assert.Equal(t, batches[0].Seq, uint64(10))
+
+	// the state machine is empty, the instances executed again
+	// are not published again
+	rr.findAndExecute()
+	assert.True(t, rr.InstanceMatrix[0][1].isExecuted())
+	assert.Equal(t, rr.feed.next, uint64(11))
+	select {
+	case b := <-s.C:
+		t.Fatalf("unexpected batch %d", b.Seq)
+	case <-time.After(100 * time.Millisecond):
+	}
+	rr.store.Close()
+}
+
+// Only the last batches should be kept in the store, and a subscription
+// should stop if the batches are removed before being delivered.
+func TestSubscribeRetention(t *testing.T) {
+	r := feedTestlibReplica(true, false)
+	defer r.store.Drop()
+	r.feed.retention = 3
+	makeChain(r, 2, false)
+	r.findAndExecute()
+	assert.Equal(t, r.feed.next, uint64(11))
+
+	_, err := r.feed.loadBatch(7)
+	assert.Equal(t, err, epaxos.ErrorNotFound)
+	_, err = r.Subscribe(7)
+	assert.Equal(t, err, ErrFeedPositionUnavailable)
+
+	s, err := r.Subscribe(8)
+	assert.NoError(t, err)
+	assert.NoError(t, r.store.Delete(r.feed.batchKey(9)))
+	batches := feedTestlibReceive(t, s, 1)
+	assert.Equal(t, batches[0].Seq, uint64(8))
+	_, ok := <-s.C
+	assert.False(t, ok)
+	assert.Equal(t, s.Err(), ErrFeedPositionUnavailable)
+}
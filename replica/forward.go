@@ -0,0 +1,350 @@
+package replica
+
+import (
+	"time"
+
+	"github.com/go-distributed/epaxos/logger"
+	"github.com/go-distributed/epaxos/message"
+)
+
+const (
+	// the max number of forwards remembered for dedup
+	maxForwardedIn = 4096
+	// the number of times a forward is sent before it fails
+	maxForwardAttempts = 3
+)
+
+// proposeResult is the result of a request, and the instance its
+// commands were proposed in, which may be in the instance space
+// of a peer if they were forwarded.
+type proposeResult struct {
+	replicaId  uint8
+	instanceId uint64
+	results    []interface{}
+	err        error
+}
+
+// pendingForward is a batch forwarded to a peer, waiting for the reply.
+type pendingForward struct {
+	msg      *message.Forward
+	to       uint8
+	sent     time.Time
+	attempts int
+	reqs     []*proposeRequest
+}
+
+type forwardKey struct {
+	from uint8
+	id   uint64
+}
+
+// forwardedIn is a forward received from a peer, it's proposed only once.
+type forwardedIn struct {
+	done  chan struct{} // closed when reply is set
+	reply *message.ForwardReply
+}
+
+// ProposeAndWait proposes the commands and returns their results once they
+// are executed. If this replica can't reach a quorum and forwarding is
+// enabled, the commands may be proposed by a peer instead.
+func (r *Replica) ProposeAndWait(cmds ...message.Command) ([]interface{}, error) {
+	return r.proposeAndWait(nil, cmds...)
+}
+
+// proposeAndWait is ProposeAndWait, it stops waiting with ErrCanceled
+// once cancel is closed, the commands may still be executed.
+func (r *Replica) proposeAndWait(cancel <-chan struct{}, cmds ...message.Command) ([]interface{}, error) {
+	req := newProposeRequest(cmds...)
+	req.done = make(chan *proposeResult, 1)
+	select {
+	case r.ProposeChan <- req:
+	case <-r.stop:
+		return nil, ErrStopped
+	case <-cancel:
+		return nil, ErrCanceled
+	}
+
+	select {
+	case res := <-req.done:
+		return res.results, res.err
+	case <-r.stop:
+		return nil, ErrStopped
+	case <-cancel:
+		return nil, ErrCanceled
+	}
+}
+
+// finish sends back the results of the request, from the results of
+// the whole batch it was proposed in, in the instance of replicaId.
+func (req *proposeRequest) finish(replicaId uint8, instanceId uint64, results []interface{}) {
+	if req.done == nil {
+		return
+	}
+	res := &proposeResult{replicaId: replicaId, instanceId: instanceId}
+	end := req.offset + len(req.cmds)
+	if len(results) < end {
+		// the instance was recovered with other commands
+		res.err = ErrProposalLost
+	} else {
+		res.results = results[req.offset:end:end]
+	}
+	req.done <- res
+}
+
+// waitResults sends back the results of the requests
+// proposed in the instance once it's executed.
+func (r *Replica) waitResults(instance *Instance, br []*proposeRequest) {
+	select {
+	case <-instance.ExecutedNotify:
+	case <-r.stop:
+		return
+	}
+	for _, req := range br {
+		req.finish(instance.rowId, instance.id, instance.Results())
+	}
+}
+
+// heardFrom records the last time a message is received from a peer.
+func (r *Replica) heardFrom(peer uint8) {
+	if peer == r.Id || int(peer) >= len(r.lastHeard) {
+		return
+	}
+	r.forwardLock.Lock()
+	r.lastHeard[peer] = time.Now()
+	r.forwardLock.Unlock()
+}
+
+// setQuorumLost is called with true when a proposal of this replica timed
+// out before being committed, and with false when one is committed.
+func (r *Replica) setQuorumLost(lost bool) {
+	r.forwardLock.Lock()
+	r.quorumLost = lost
+	r.forwardLock.Unlock()
+
+	if lost {
+		// wakes up the adaptive propose loop, which may
+		// have requests to forward while its slots are full
+		select {
+		case r.slotFreed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// QuorumLost returns true if the proposals of this replica
+// time out, because it can't reach a quorum.
+func (r *Replica) QuorumLost() bool {
+	r.forwardLock.Lock()
+	defer r.forwardLock.Unlock()
+	return r.quorumLost
+}
+
+// forwardPeer returns the peer heard from most recently, within the
+// timeout interval, and not in path.
+func (r *Replica) forwardPeer(path []uint8) (uint8, bool) {
+	r.forwardLock.Lock()
+	defer r.forwardLock.Unlock()
+
+	var best uint8
+	var bestHeard time.Time
+	found := false
+	for peer, heard := range r.lastHeard {
+		if uint8(peer) == r.Id || time.Since(heard) > r.TimeoutInterval {
+			continue
+		}
+		if inPath(uint8(peer), path) {
+			continue
+		}
+		if !found || heard.After(bestHeard) {
+			best, bestHeard, found = uint8(peer), heard, true
+		}
+	}
+	return best, found
+}
+
+func inPath(id uint8, path []uint8) bool {
+	for _, p := range path {
+		if p == id {
+			return true
+		}
+	}
+	return false
+}
+
+// tryForward forwards the batch to a peer if this replica can't reach
+// a quorum, it returns false if the batch should be proposed locally.
+// Only batches of requests waiting for their results are forwarded, the
+// other ones expect the id of an instance in this instance space.
+func (r *Replica) tryForward(cmds message.Commands, br []*proposeRequest) bool {
+	if !r.enableForwarding || !r.QuorumLost() {
+		return false
+	}
+	for _, req := range br {
+		if req.done == nil {
+			return false
+		}
+	}
+
+	path := []uint8{r.Id}
+	for _, req := range br {
+		for _, p := range req.path {
+			if !inPath(p, path) {
+				path = append(path, p)
+			}
+		}
+	}
+	peer, ok := r.forwardPeer(path)
+	if !ok {
+		return false
+	}
+
+	r.forwardLock.Lock()
+	r.nextForwardId++
+	f := &pendingForward{
+		msg: &message.Forward{
+			From:      r.Id,
+			ForwardId: r.nextForwardId,
+			Path:      path,
+			Cmds:      cmds,
+		},
+		to:       peer,
+		sent:     time.Now(),
+		attempts: 1,
+		reqs:     br,
+	}
+	r.pendingForwards[f.msg.ForwardId] = f
+	r.forwardLock.Unlock()
+
+	r.logger.Info(logger.Dispatch, 1, "forward", logger.Fields{
+		"forward": f.msg.ForwardId,
+		"to":      peer,
+	})
+	r.Transporter.Send(peer, f.msg)
+
+	// the commands are not proposed in this instance space, the
+	// instance is sent back with the results instead
+	for _, req := range br {
+		close(req.id)
+	}
+	return true
+}
+
+// resendForwards resends the forwards not replied in time to the same
+// peer, which won't propose them again. After maxForwardAttempts, the
+// requests fail with ErrForwardTimeout, their commands may still be
+// executed by the peer.
+func (r *Replica) resendForwards() {
+	r.forwardLock.Lock()
+	defer r.forwardLock.Unlock()
+
+	for id, f := range r.pendingForwards {
+		if time.Since(f.sent) <= r.TimeoutInterval {
+			continue
+		}
+		if f.attempts == maxForwardAttempts {
+			delete(r.pendingForwards, id)
+			r.logger.Info(logger.Dispatch, 1, "forward timed out", logger.Fields{
+				"forward": id,
+				"to":      f.to,
+			})
+			for _, req := range f.reqs {
+				req.done <- &proposeResult{err: ErrForwardTimeout}
+			}
+			continue
+		}
+		f.attempts++
+		f.sent = time.Now()
+		r.Transporter.Send(f.to, f.msg)
+	}
+}
+
+// handleForward proposes forwarded commands, and replies with the results
+// once they are executed. It blocks, so it runs in its own goroutine.
+func (r *Replica) handleForward(f *message.Forward) {
+	key := forwardKey{f.From, f.ForwardId}
+
+	r.forwardLock.Lock()
+	in, seen := r.forwardedIn[key]
+	if !seen {
+		in = &forwardedIn{done: make(chan struct{})}
+		r.forwardedIn[key] = in
+		r.forwardedOrder = append(r.forwardedOrder, key)
+		if len(r.forwardedOrder) > maxForwardedIn {
+			delete(r.forwardedIn, r.forwardedOrder[0])
+			r.forwardedOrder = r.forwardedOrder[1:]
+		}
+	}
+	r.forwardLock.Unlock()
+
+	if !seen {
+		req := newProposeRequest(f.Cmds...)
+		req.path = f.Path
+		req.done = make(chan *proposeResult, 1)
+		select {
+		case r.ProposeChan <- req:
+		case <-r.stop:
+			return
+		}
+
+		var res *proposeResult
+		select {
+		case res = <-req.done:
+		case <-r.stop:
+			return
+		}
+
+		// the instance may be in the instance space of another peer,
+		// if the commands were forwarded again
+		in.reply = &message.ForwardReply{
+			From:       r.Id,
+			ForwardId:  f.ForwardId,
+			ReplicaId:  res.replicaId,
+			InstanceId: res.instanceId,
+			Results:    encodableResults(res.results),
+		}
+		close(in.done)
+	}
+
+	select {
+	case <-in.done:
+	case <-r.stop:
+		return
+	}
+	r.Transporter.Send(f.From, in.reply)
+}
+
+// handleForwardReply sends back the results of a forward to the requests.
+func (r *Replica) handleForwardReply(rep *message.ForwardReply) {
+	r.forwardLock.Lock()
+	f, ok := r.pendingForwards[rep.ForwardId]
+	delete(r.pendingForwards, rep.ForwardId)
+	r.forwardLock.Unlock()
+
+	if !ok {
+		return // a reply to a resent forward
+	}
+	r.logger.Info(logger.Dispatch, 1, "forward proposed", logger.Fields{
+		"forward":  rep.ForwardId,
+		"row":      rep.ReplicaId,
+		"instance": rep.InstanceId,
+	})
+	for _, req := range f.reqs {
+		req.finish(rep.ReplicaId, rep.InstanceId, rep.Results)
+	}
+}
+
+// encodableResults replaces error results by ResultError,
+// which can be sent to other replicas.
+func encodableResults(results []interface{}) []interface{} {
+	if results == nil {
+		return nil
+	}
+	res := make([]interface{}, len(results))
+	for i := range results {
+		res[i] = results[i]
+		if err, ok := results[i].(error); ok {
+			res[i] = ResultError(err.Error())
+		}
+	}
+	return res
+}
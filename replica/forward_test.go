@@ -0,0 +1,188 @@
+package replica
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/test"
+	"github.com/go-distributed/epaxos/transporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func forwardTestlibCluster(size int) []*Replica {
+	nodes := make([]*Replica, size)
+	chs := make([]chan message.Message, size)
+	for i := range nodes {
+		r, err := New(&Param{
+			ReplicaId:        uint8(i),
+			Size:             uint8(size),
+			TimeoutInterval:  time.Second * 50, // disable timeout
+			StateMachine:     test.NewDummySM(),
+			Transporter:      transporter.NewDummyTR(uint8(i), size),
+			EnableForwarding: true,
+		})
+		if err != nil {
+			panic(err)
+		}
+		nodes[i] = r
+		chs[i] = r.MessageChan
+	}
+	for _, r := range nodes {
+		r.Transporter.(*transporter.DummyTransporter).RegisterChannels(chs)
+		r.Start()
+	}
+	return nodes
+}
+
+func forwardTestlibStop(nodes []*Replica) {
+	for _, r := range nodes {
+		r.Stop()
+	}
+}
+
+func TestProposeAndWait(t *testing.T) {
+	nodes := forwardTestlibCluster(3)
+	defer forwardTestlibStop(nodes)
+
+	results, err := nodes[0].ProposeAndWait(message.Command("a"), message.Command("invalid"))
+	assert.NoError(t, err)
+	assert.Equal(t, results, []interface{}{"a", test.ErrInvalidCommand})
+	assert.False(t, nodes[0].QuorumLost())
+}
+
+// A replica which can't reach a quorum should forward proposals
+// to a peer, and get the results back from it.
+func TestProposeAndWaitForwarded(t *testing.T) {
+	nodes := forwardTestlibCluster(3)
+	defer forwardTestlibStop(nodes)
+
+	nodes[0].setQuorumLost(true)
+	nodes[0].heardFrom(2)
+
+	results, err := nodes[0].ProposeAndWait(message.Command("a"), message.Command("invalid"))
+	assert.NoError(t, err)
+	assert.Equal(t, results, []interface{}{"a", ResultError(test.ErrInvalidCommand.Error())})
+	assert.Equal(t, len(nodes[0].pendingForwards), 0)
+
+	// proposed by replica 2 in its instance space
+	<-nodes[2].InstanceMatrix[2][1].ExecutedNotify
+	assert.Equal(t, nodes[2].InstanceMatrix[2][1].Commands(),
+		message.Commands{message.Command("a"), message.Command("invalid")})
+	assert.Nil(t, nodes[0].InstanceMatrix[0][1])
+
+	// a plain proposal expects an id, so it's not forwarded
+	assert.Equal(t, <-nodes[0].Propose(message.Command("b")), uint64(1))
+}
+
+// A proposal should not wait for room in ProposeChan once the replica
+// is stopped, or the proposal is canceled.
+func TestProposeAndWaitFull(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	for len(r.ProposeChan) < cap(r.ProposeChan) {
+		r.ProposeChan <- newProposeRequest(message.Command("a"))
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	_, err := r.proposeAndWait(cancel, message.Command("b"))
+	assert.Equal(t, err, ErrCanceled)
+
+	close(r.stop)
+	_, err = r.proposeAndWait(nil, message.Command("b"))
+	assert.Equal(t, err, ErrStopped)
+}
+
+// A forward not replied after maxForwardAttempts should fail its requests.
+func TestResendForwardsTimeout(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	r.TimeoutInterval = time.Millisecond
+
+	req := newProposeRequest(message.Command("a"))
+	req.done = make(chan *proposeResult, 1)
+	r.pendingForwards[1] = &pendingForward{
+		msg:      &message.Forward{From: 0, ForwardId: 1},
+		to:       1,
+		sent:     time.Now().Add(-time.Second),
+		attempts: maxForwardAttempts,
+		reqs:     []*proposeRequest{req},
+	}
+	r.pendingForwards[2] = &pendingForward{
+		msg:      &message.Forward{From: 0, ForwardId: 2},
+		to:       1,
+		sent:     time.Now().Add(time.Second),
+		attempts: 1,
+	}
+
+	r.resendForwards()
+	assert.Equal(t, (<-req.done).err, ErrForwardTimeout)
+	assert.Equal(t, len(r.pendingForwards), 1)
+	assert.Equal(t, r.pendingForwards[2].attempts, 1)
+}
+
+// A resent forward should not be proposed again.
+func TestHandleForwardDedup(t *testing.T) {
+	nodes := forwardTestlibCluster(3)
+	defer forwardTestlibStop(nodes)
+
+	// replica 0 doesn't expect the replies
+	f := &message.Forward{
+		From:      0,
+		ForwardId: 7,
+		Path:      []uint8{0},
+		Cmds:      message.Commands{message.Command("a")},
+	}
+	nodes[1].handleForward(f)
+	nodes[1].handleForward(f)
+
+	assert.Equal(t, nodes[1].ProposeNum, uint64(2))
+	assert.Equal(t, nodes[1].forwardedIn[forwardKey{0, 7}].reply.InstanceId, uint64(1))
+	assert.Equal(t, nodes[1].forwardedIn[forwardKey{0, 7}].reply.Results, []interface{}{"a"})
+}
+
+// This func tests the choice of the peer to forward to.
+func TestForwardPeer(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	r.TimeoutInterval = time.Second
+
+	_, ok := r.forwardPeer(nil)
+	assert.False(t, ok)
+
+	r.heardFrom(0) // self
+	r.heardFrom(2)
+	r.lastHeard[3] = time.Now().Add(-time.Minute)
+	r.heardFrom(1)
+
+	peer, ok := r.forwardPeer([]uint8{0})
+	assert.True(t, ok)
+	assert.Equal(t, peer, uint8(1))
+
+	peer, ok = r.forwardPeer([]uint8{0, 1})
+	assert.True(t, ok)
+	assert.Equal(t, peer, uint8(2))
+
+	_, ok = r.forwardPeer([]uint8{0, 1, 2})
+	assert.False(t, ok)
+}
+
+// Timed out proposals of the replica should mark the quorum lost,
+// until one of them is committed.
+func TestQuorumLost(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	r.enableForwarding = true
+	r.TimeoutInterval = time.Millisecond
+
+	i := NewInstance(r, r.Id, 1)
+	i.ballot = r.makeInitialBallot()
+	i.status = preAccepted
+	r.InstanceMatrix[r.Id][1] = i
+	r.MaxInstanceNum[r.Id] = 1
+
+	time.Sleep(2 * time.Millisecond)
+	r.checkTimeout()
+	assert.True(t, r.QuorumLost())
+	<-r.timeouts
+
+	i.enterCommitted()
+	assert.False(t, r.QuorumLost())
+}
@@ -0,0 +1,181 @@
+package replica
+
+// This file implements the dependency graph of the instances not executed
+// yet, to find out why the execution is stuck. Starting from the first
+// instance not executed in each row, the dependencies of committed
+// instances are walked, and the instances not committed or missing, which
+// block the execution, are highlighted. It's served as json or graphviz
+// dot on /admin/graph.
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// the max number of nodes in the graph
+const maxGraphNodes = 1024
+
+// GraphNode is an instance in the dependency graph.
+type GraphNode struct {
+	RowId    uint8
+	Id       uint64
+	Status   string
+	Missing  bool // the instance is not in the matrix
+	Blocking bool // not committed or missing
+	Cmds     []string
+}
+
+// GraphEdge is a dependency of an instance on another one.
+type GraphEdge struct {
+	FromRow uint8
+	FromId  uint64
+	ToRow   uint8
+	ToId    uint64
+}
+
+// DependencyGraph is the graph of the instances not executed yet.
+type DependencyGraph struct {
+	ReplicaId uint8
+	Nodes     []*GraphNode
+	Edges     []GraphEdge
+	Truncated bool // maxGraphNodes was reached
+}
+
+// DependencyGraph walks the dependencies of the instances not executed
+// yet, from the first one of each row. Like in the execution, dependencies
+// on checkpoints are skipped.
+func (r *Replica) DependencyGraph() *DependencyGraph {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	g := &DependencyGraph{
+		ReplicaId: r.Id,
+		Nodes:     make([]*GraphNode, 0),
+		Edges:     make([]GraphEdge, 0),
+	}
+	visited := make(map[instanceRef]bool)
+	queue := make([]instanceRef, 0)
+
+	for row := uint8(0); row < r.Size; row++ {
+		if first := r.firstNotExecuted(row); first <= r.MaxInstanceNum[row] {
+			ref := instanceRef{row, first}
+			visited[ref] = true
+			queue = append(queue, ref)
+		}
+	}
+
+	for len(queue) > 0 {
+		if len(g.Nodes) == maxGraphNodes {
+			g.Truncated = true
+			break
+		}
+		ref := queue[0]
+		queue = queue[1:]
+
+		i := r.instanceAt(ref.rowId, ref.id)
+		if i == nil {
+			g.Nodes = append(g.Nodes, &GraphNode{
+				RowId:    ref.rowId,
+				Id:       ref.id,
+				Status:   statusString(nilStatus),
+				Missing:  true,
+				Blocking: true,
+			})
+			continue
+		}
+		committed := i.isAtStatus(committed)
+		g.Nodes = append(g.Nodes, &GraphNode{
+			RowId:    ref.rowId,
+			Id:       ref.id,
+			Status:   i.StatusString(),
+			Blocking: !committed,
+			Cmds:     commandStrings(i.cmds),
+		})
+		if !committed {
+			continue // the deps may still change
+		}
+
+		for dep, id := range i.deps {
+			if id <= r.ExecutedUpTo[dep] || r.IsCheckpoint(id) {
+				continue
+			}
+			to := instanceRef{uint8(dep), id}
+			g.Edges = append(g.Edges, GraphEdge{
+				FromRow: ref.rowId,
+				FromId:  ref.id,
+				ToRow:   to.rowId,
+				ToId:    to.id,
+			})
+			if !visited[to] {
+				visited[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	return g
+}
+
+// Blocking returns the nodes blocking the execution.
+func (g *DependencyGraph) Blocking() []*GraphNode {
+	res := make([]*GraphNode, 0)
+	for _, n := range g.Nodes {
+		if n.Blocking {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+func graphNodeName(row uint8, id uint64) string {
+	return fmt.Sprintf("\"%v.%v\"", row, id)
+}
+
+// WriteDOT writes the graph in the graphviz dot format, blocking
+// instances are red, and missing ones dashed.
+func (g *DependencyGraph) WriteDOT(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "digraph replica%v {\n\tnode [shape=box];\n", g.ReplicaId); err != nil {
+		return err
+	}
+	for _, n := range g.Nodes {
+		attrs := ""
+		switch {
+		case n.Missing:
+			attrs = ", style=dashed, color=red"
+		case n.Blocking:
+			attrs = ", style=filled, fillcolor=red"
+		}
+		if _, err := fmt.Fprintf(w, "\t%s [label=\"[%v][%v]\\n%s\"%s];\n",
+			graphNodeName(n.RowId, n.Id), n.RowId, n.Id, n.Status, attrs); err != nil {
+			return err
+		}
+	}
+	for _, e := range g.Edges {
+		if _, err := fmt.Fprintf(w, "\t%s -> %s;\n",
+			graphNodeName(e.FromRow, e.FromId), graphNodeName(e.ToRow, e.ToId)); err != nil {
+			return err
+		}
+	}
+	if g.Truncated {
+		if _, err := fmt.Fprintf(w, "\tlabel=\"truncated at %v instances\";\n", maxGraphNodes); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// serveGraph serves the dependency graph, as json by default,
+// or as dot with format=dot.
+func (r *Replica) serveGraph(w http.ResponseWriter, req *http.Request) {
+	g := r.DependencyGraph()
+	switch req.URL.Query().Get("format") {
+	case "", "json":
+		writeJSON(w, g)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		g.WriteDOT(w)
+	default:
+		http.Error(w, "invalid format", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,70 @@
+package replica
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-distributed/epaxos/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func graphTestlibNodes(g *DependencyGraph) []instanceRef {
+	refs := make([]instanceRef, len(g.Nodes))
+	for k, n := range g.Nodes {
+		refs[k] = instanceRef{n.RowId, n.Id}
+	}
+	return refs
+}
+
+// The graph should include the dependencies of committed instances,
+// and highlight the missing and not committed ones.
+func TestDependencyGraph(t *testing.T) {
+	r := adminTestlibReplica()
+	adminTestlibAdd(r, 0, 1, committed, message.Dependencies{0, 2, 0, 0, 0})
+	adminTestlibAdd(r, 1, 1, committed, message.Dependencies{0, 0, 0, 0, 0})
+	adminTestlibAdd(r, 1, 2, accepted, message.Dependencies{1, 1, 0, 0, 0})
+	adminTestlibAdd(r, 2, 2, committed, message.Dependencies{0, 0, 1, 0, 0})
+	r.ExecutedUpTo[1] = 1
+
+	g := r.DependencyGraph()
+	assert.Equal(t, graphTestlibNodes(g), []instanceRef{{0, 1}, {1, 2}, {2, 1}})
+	assert.Equal(t, g.Edges, []GraphEdge{{0, 1, 1, 2}})
+	assert.False(t, g.Nodes[0].Blocking)
+	assert.True(t, g.Nodes[1].Blocking)
+	assert.True(t, g.Nodes[2].Missing)
+	assert.Equal(t, len(g.Blocking()), 2)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, g.WriteDOT(buf))
+	dot := buf.String()
+	assert.True(t, strings.HasPrefix(dot, "digraph replica0 {\n"))
+	assert.True(t, strings.Contains(dot, "\t\"0.1\" -> \"1.2\";\n"))
+	assert.True(t, strings.Contains(dot, "\t\"1.2\" [label=\"[1][2]\\nAccepted\", style=filled, fillcolor=red];\n"))
+	assert.True(t, strings.Contains(dot, "\t\"2.1\" [label=\"[2][1]\\nNilStatus\", style=dashed, color=red];\n"))
+
+	// served as json and dot
+	var served DependencyGraph
+	assert.Equal(t, adminTestlibGet(r, "/admin/graph", &served), 200)
+	b, _ := json.Marshal(g)
+	expected := new(DependencyGraph)
+	json.Unmarshal(b, expected)
+	assert.Equal(t, &served, expected)
+	assert.Equal(t, adminTestlibGet(r, "/admin/graph?format=dot", nil), 200)
+	assert.Equal(t, adminTestlibGet(r, "/admin/graph?format=svg", nil), 400)
+}
+
+// Checkpoints should be neither in the graph nor blocking.
+func TestDependencyGraphCheckpoint(t *testing.T) {
+	r := adminTestlibReplica()
+	adminTestlibAdd(r, 0, 1025, committed, message.Dependencies{0, 1024, 0, 0, 0})
+	r.ExecutedUpTo[0] = 1023
+	r.ExecutedUpTo[1] = 1023
+	r.MaxInstanceNum[1] = 1024
+
+	g := r.DependencyGraph()
+	assert.Equal(t, graphTestlibNodes(g), []instanceRef{{0, 1025}})
+	assert.Equal(t, g.Edges, []GraphEdge{})
+	assert.Equal(t, len(g.Blocking()), 0)
+}
@@ -0,0 +1,65 @@
+package replica
+
+// This file implements the http endpoint of the replica, which serves
+// the metrics on /metrics, the slow instances on /traces/slow, the
+// admin api on /admin/ and the client api on /client/ if they're
+// enabled. It's only started if Param.HTTPAddr is set, Handler() can be
+// mounted on another server otherwise.
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-distributed/epaxos/logger"
+)
+
+// the max time to wait for the requests in flight when stopping
+const httpShutdownTimeout = time.Second * 5
+
+func (r *Replica) initHTTP() {
+	r.httpMux = http.NewServeMux()
+	r.httpMux.Handle("/metrics", r.registry)
+	r.httpMux.HandleFunc("/traces/slow", r.serveSlowTraces)
+	if r.enableAdmin {
+		r.initAdmin()
+	}
+	if r.enableClientAPI {
+		r.initClientAPI()
+	}
+}
+
+// Handler returns the http handler serving the endpoints of the replica.
+func (r *Replica) Handler() http.Handler {
+	return r.httpMux
+}
+
+// startHTTP listens on HTTPAddr and serves the endpoints until the
+// replica is stopped.
+func (r *Replica) startHTTP() error {
+	l, err := net.Listen("tcp", r.httpAddr)
+	if err != nil {
+		return err
+	}
+	r.httpServer = &http.Server{Handler: r.httpMux}
+	go func() {
+		if err := r.httpServer.Serve(l); err != http.ErrServerClosed {
+			r.logger.Warning("http server error", logger.Fields{"err": err})
+		}
+	}()
+	return nil
+}
+
+// stopHTTP stops accepting requests, and waits at most
+// httpShutdownTimeout for the ones in flight, e.g. client proposals.
+func (r *Replica) stopHTTP() {
+	if r.httpServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	if err := r.httpServer.Shutdown(ctx); err != nil {
+		r.httpServer.Close()
+	}
+}
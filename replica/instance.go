@@ -24,11 +24,14 @@ package replica
 // - The initial ballot is (epoch, 0, replicaId)
 // @decision (04/18/14):
 // - Delete rejections to avoid complexity
+// @decision (10/18/26):
+// - A no-op can also be proposed explicitly, it's proposed as Commands(nil).
 
 import (
 	"fmt"
 	"time"
 
+	"github.com/go-distributed/epaxos/logger"
 	"github.com/go-distributed/epaxos/message"
 )
 
@@ -64,10 +67,18 @@ type Instance struct {
 	rowId    uint8
 	id       uint64
 	executed bool
+	replayed bool          // executed before a restart, and not applied by the state machine
+	results  []interface{} // results of cmds, set on execution
+	execHash uint64        // rolling hash of its instance space, 0 if none
+	events   []TraceEvent  // status transitions, only if tracing is enabled
+	// the scc it was executed in, in execution order, set on execution
+	executedWith []instanceRef
 
 	// tarjan SCC
 	sccIndex   int
 	sccLowlink int
+	sccOnStack bool
+	sccRoot    *Instance // the root of the resolution which visited it
 
 	CommittedNotify chan struct{}
 	ExecutedNotify  chan struct{}
@@ -312,7 +323,7 @@ func (i *Instance) preAcceptedProcess(m message.Message) (action uint8, msg mess
 	case *message.Timeout:
 		return i.handleTimeout(content)
 	case *message.Prepare:
-		if content.Ballot.Compare(i.ballot) < 0 {
+		if content.Ballot.Compare(i.ballot) <= 0 { // including a delayed prepare of the current ballot
 			return noAction, nil
 		}
 		return i.handlePrepare(content)
@@ -365,7 +376,7 @@ func (i *Instance) acceptedProcess(m message.Message) (action uint8, msg message
 	case *message.Timeout:
 		return i.handleTimeout(content)
 	case *message.Prepare:
-		if content.Ballot.Compare(i.ballot) < 0 {
+		if content.Ballot.Compare(i.ballot) <= 0 { // including a delayed prepare of the current ballot
 			return noAction, nil
 		}
 		return i.handlePrepare(content)
@@ -483,7 +494,7 @@ func (i *Instance) preparingProcess(m message.Message) (action uint8, msg messag
 
 // a propose will broadcasted to fast quorum in pre-accept message.
 func (i *Instance) handlePropose(p *message.Propose) (action uint8, msg *message.PreAccept) {
-	if p.Cmds == nil || !i.isNewBorn() || !i.isAtStatus(nilStatus) {
+	if !i.isNewBorn() || !i.isAtStatus(nilStatus) {
 		panic("")
 	}
 
@@ -560,6 +571,7 @@ func (i *Instance) commonPreAcceptedNextStep() (action uint8, msg message.Messag
 	if okCount == i.replica.fastQuorum() ||
 		replyCount == i.replica.fastQuorum() && i.ableToFastPath() {
 		// TODO: persistent
+		i.replica.metrics.fastPath.Inc()
 		i.enterCommitted()
 		return broadcastAction, i.makeCommit()
 	}
@@ -569,6 +581,7 @@ func (i *Instance) commonPreAcceptedNextStep() (action uint8, msg message.Messag
 	// - a mix of preacept-ok/-reply implies different and deps.
 	if okCount+replyCount >= i.replica.quorum() && !i.ableToFastPath() {
 		// TODO: persistent
+		i.replica.metrics.slowPath.Inc()
 		i.enterAcceptedAsSender()
 		return broadcastAction, i.makeAccept()
 	}
@@ -656,6 +669,7 @@ func (i *Instance) handleAccept(a *message.Accept) (action uint8, msg *message.A
 	}
 
 	i.cmds, i.deps, i.ballot = a.Cmds.Clone(), a.Deps.Clone(), a.Ballot.Clone()
+	i.replica.indexInstance(i)
 	i.enterAcceptedAsReceiver()
 
 	return replyAction, i.makeAcceptReply(true)
@@ -701,6 +715,7 @@ func (i *Instance) handleCommit(c *message.Commit) (action uint8, msg message.Me
 	}
 
 	i.cmds, i.deps = c.Cmds.Clone(), c.Deps.Clone()
+	i.replica.indexInstance(i)
 	i.enterCommitted()
 
 	// TODO: Do we need to clear unnecessary objects to save more memory?
@@ -711,10 +726,12 @@ func (i *Instance) handleCommit(c *message.Commit) (action uint8, msg message.Me
 func (i *Instance) revertAndHandlePrepare(p *message.Prepare) (action uint8, msg *message.PrepareReply) {
 	i.checkStatus(preparing)
 	i.status = i.recoveryInfo.formerStatus
+	i.traceEvent(eventRecovered)
 	return i.handlePrepare(p)
 }
 
 func (i *Instance) handleTimeout(p *message.Timeout) (action uint8, msg *message.Prepare) {
+	i.replica.metrics.prepares.Inc()
 	i.enterPreparing()
 	return broadcastAction, i.makePrepare()
 }
@@ -889,6 +906,7 @@ func (i *Instance) handlePreAcceptedPrepareReply(p *message.PrepareReply) {
 func (i *Instance) loadRecoveryInfo() {
 	ir := i.recoveryInfo
 	i.cmds, i.deps = ir.cmds.Clone(), ir.deps.Clone()
+	i.replica.indexInstance(i)
 
 	if ir.ballot.Compare(i.ballot) > 0 || ir.statusIs(committed) {
 		// to make sure we don't decrease the ballot except
@@ -1023,28 +1041,36 @@ func (i *Instance) enterPreAcceptedAsSender() {
 	i.checkStatus(nilStatus, preparing)
 	i.status = preAccepted
 	i.info.reset()
+	i.traceEvent(eventPreAccepted)
 }
 
 func (i *Instance) enterPreAcceptedAsReceiver() {
 	i.checkStatus(nilStatus, preparing, preAccepted)
 	i.status = preAccepted
+	i.traceEvent(eventPreAccepted)
 }
 
 func (i *Instance) enterAcceptedAsSender() {
 	i.checkStatus(nilStatus, preAccepted, preparing)
 	i.status = accepted
 	i.info.reset()
+	i.traceEvent(eventAccepted)
 }
 
 func (i *Instance) enterAcceptedAsReceiver() {
 	i.checkStatus(nilStatus, preAccepted, preparing, accepted)
 	i.status = accepted
+	i.traceEvent(eventAccepted)
 }
 
 func (i *Instance) enterCommitted() {
 	i.checkStatus(nilStatus, preAccepted, preparing, accepted)
 	i.status = committed
+	i.traceEvent(eventCommitted)
 	close(i.CommittedNotify)
+	if i.replica != nil {
+		i.replica.notifyCommitted(i)
+	}
 }
 
 func (i *Instance) enterPreparing() {
@@ -1063,6 +1089,7 @@ func (i *Instance) enterPreparing() {
 	i.ballot.IncNumber()
 
 	i.status = preparing
+	i.traceEvent(eventPreparing)
 }
 
 // checkStatus checks the status of the instance
@@ -1105,8 +1132,17 @@ func (i *Instance) isExecuted() bool {
 	return i.executed
 }
 
+// Results returns the results of executing the commands of
+// the instance, or nil if it's not executed yet.
+func (i *Instance) Results() []interface{} {
+	return i.results
+}
+
 func (i *Instance) SetExecuted() {
 	i.executed = true
+	if i.replica != nil {
+		i.replica.finishTrace(i)
+	}
 	close(i.ExecutedNotify)
 }
 
@@ -1137,6 +1173,17 @@ func (i *Instance) StatusString() string {
 	}
 }
 
+// logFields returns the fields describing the instance in log messages.
+func (i *Instance) logFields() logger.Fields {
+	return logger.Fields{
+		"row":      i.rowId,
+		"instance": i.id,
+		"ballot":   i.ballot.String(),
+		"status":   i.StatusString(),
+		"deps":     i.deps,
+	}
+}
+
 func (i *Instance) Pack() *PackedInstance {
 	return &PackedInstance{
 		Cmds:     i.cmds.Clone(),
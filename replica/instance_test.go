@@ -191,8 +191,11 @@ func TestNilStatusProcessWithHandlePropose(t *testing.T) {
 	i := commonTestlibExampleNilStatusInstance()
 	i.rowId = i.replica.Id // to avoid panic
 
-	// test panics empty propose
-	assert.Panics(t, func() { i.nilStatusProcess(&message.Propose{}) })
+	// test empty propose is a no-op
+	noop := commonTestlibExampleNilStatusInstance()
+	noop.rowId = noop.replica.Id
+	assert.NotPanics(t, func() { noop.nilStatusProcess(&message.Propose{}) })
+	assert.Nil(t, noop.cmds)
 
 	action, m := i.nilStatusProcess(p)
 	if !assert.IsType(t, &message.PreAccept{}, m) {
@@ -666,6 +669,13 @@ func TestPreAcceptedProcessWithIgnorePrepare(t *testing.T) {
 	assert.Equal(t, action, noAction)
 	assert.Equal(t, m, nil)
 	assertEqualInstance(t, inst, originalInst)
+
+	// a delayed prepare of the current ballot is also ignored
+	pr.Ballot = largerBallot.Clone()
+	action, m = inst.preAcceptedProcess(pr)
+	assert.Equal(t, action, noAction)
+	assert.Equal(t, m, nil)
+	assertEqualInstance(t, inst, originalInst)
 }
 
 // TestPreAcceptedProcessWithHandlePrepare asserts that
@@ -1194,6 +1204,13 @@ func TestAcceptedProcessWithIgnorePrepare(t *testing.T) {
 	assert.Equal(t, action, noAction)
 	assert.Equal(t, msg, nil)
 	assertEqualInstance(t, inst, originalInst)
+
+	// a delayed prepare of the current ballot is also ignored
+	p.Ballot = largeBallot.Clone()
+	action, msg = inst.acceptedProcess(p)
+	assert.Equal(t, action, noAction)
+	assert.Equal(t, msg, nil)
+	assertEqualInstance(t, inst, originalInst)
 }
 
 // TestAcceptedProcessWithHandlePrepare asserts that
@@ -2047,8 +2064,10 @@ func TestHandlePropose(t *testing.T) {
 		InstanceId: i.id,
 		Cmds:       nil,
 	}
-	// should panic is cmds == nil
-	assert.Panics(t, func() { i.handlePropose(p) })
+	// should propose a no-op if cmds == nil
+	act, _ := i.handlePropose(p)
+	assert.Equal(t, act, fastQuorumAction)
+	assert.Nil(t, i.cmds)
 
 	// should panic if the instance not at nilStatus
 	i = commonTestlibExamplePreAcceptedInstance()
@@ -0,0 +1,118 @@
+package replica
+
+// This file defines the metrics of the replica, see the metrics package.
+// Counters are updated by the event and execute loops, the instance
+// counts and the execution lag are computed on every scrape.
+// @decision(10/18/26):
+// - The instance matrix is read holding the replica lock while scraping,
+// - so the counts are taken between two events of the event loop, and
+// - never in the middle of marking instances executed.
+
+import (
+	"fmt"
+
+	"github.com/go-distributed/epaxos/metrics"
+)
+
+var metricStatuses = []uint8{nilStatus, preparing, preAccepted, accepted, committed}
+
+type replicaMetrics struct {
+	fastPath *metrics.Counter
+	slowPath *metrics.Counter
+	prepares *metrics.Counter
+	sccSizes *metrics.Histogram
+}
+
+// metricsRegisterer is implemented by transporters exposing metrics.
+type metricsRegisterer interface {
+	RegisterMetrics(reg *metrics.Registry)
+}
+
+func (r *Replica) initMetrics() {
+	reg := metrics.NewRegistry()
+	r.registry = reg
+
+	r.metrics.fastPath = reg.Counter("epaxos_preaccept_decisions_total",
+		"Instances of this replica committed on the fast path or sent to the slow path.",
+		metrics.Labels{"path": "fast"})
+	r.metrics.slowPath = reg.Counter("epaxos_preaccept_decisions_total",
+		"Instances of this replica committed on the fast path or sent to the slow path.",
+		metrics.Labels{"path": "slow"})
+	r.metrics.prepares = reg.Counter("epaxos_prepares_total",
+		"Prepare rounds started to recover timed out instances.", nil)
+	r.metrics.sccSizes = reg.Histogram("epaxos_scc_size",
+		"Number of instances in each executed strongly connected component.",
+		nil, metrics.ExponentialBuckets(1, 2, 8))
+
+	for _, status := range metricStatuses {
+		status := status
+		reg.GaugeFunc("epaxos_instances",
+			"Instances not executed yet, by status.",
+			metrics.Labels{"status": statusString(status)},
+			func() float64 { return float64(r.countInstances(status)) })
+	}
+	for row := uint8(0); row < r.Size; row++ {
+		row := row
+		reg.GaugeFunc("epaxos_execution_lag",
+			"Instances seen but not executed yet in each instance space.",
+			metrics.Labels{"row": fmt.Sprint(row)},
+			func() float64 { return float64(r.executionLag(row)) })
+	}
+	reg.GaugeFunc("epaxos_outstanding_proposals",
+		"Instances of this replica proposed and not committed yet.",
+		nil, func() float64 { return float64(r.outstanding()) })
+
+	if tr, ok := r.Transporter.(metricsRegisterer); ok {
+		tr.RegisterMetrics(reg)
+	}
+}
+
+// Metrics returns the registry of the metrics of the replica.
+func (r *Replica) Metrics() *metrics.Registry {
+	return r.registry
+}
+
+// PathCounts returns the number of instances of this replica committed
+// on the fast path, and sent to the slow path.
+func (r *Replica) PathCounts() (fast, slow uint64) {
+	return r.metrics.fastPath.Value(), r.metrics.slowPath.Value()
+}
+
+// countInstances returns the number of instances at the status,
+// and not executed yet.
+func (r *Replica) countInstances(status uint8) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	count := 0
+	for row := uint8(0); row < r.Size; row++ {
+		instances := r.InstanceMatrix[row]
+		for j := r.ExecutedUpTo[row] + 1; j <= r.MaxInstanceNum[row]; j++ {
+			if j >= uint64(len(instances)) {
+				break
+			}
+			if i := instances[j]; i != nil && i.status == status && !i.executed {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// executionLag returns the number of instances seen
+// but not executed yet in the instance space.
+func (r *Replica) executionLag(row uint8) uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	max, executed := r.MaxInstanceNum[row], r.ExecutedUpTo[row]
+	if max < executed {
+		return 0
+	}
+	return max - executed
+}
+
+func statusString(status uint8) string {
+	i := &Instance{status: status}
+	return i.StatusString()
+}
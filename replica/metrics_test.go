@@ -0,0 +1,66 @@
+package replica
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-distributed/epaxos/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func metricsTestlibScrape(r *Replica) string {
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+// Proposals of the replica should show up in the counters.
+func TestMetrics(t *testing.T) {
+	nodes := forwardTestlibCluster(3)
+	defer forwardTestlibStop(nodes)
+
+	for _, cmd := range []string{"a", "b"} {
+		_, err := nodes[0].ProposeAndWait(message.Command(cmd))
+		assert.NoError(t, err)
+	}
+	r := nodes[0]
+	assert.Equal(t, r.metrics.fastPath.Value(), uint64(2))
+	assert.Equal(t, r.metrics.slowPath.Value(), uint64(0))
+	assert.True(t, r.metrics.sccSizes.Count() > 0)
+	fast, slow := r.PathCounts()
+	assert.Equal(t, fast, uint64(2))
+	assert.Equal(t, slow, uint64(0))
+
+	text := metricsTestlibScrape(r)
+	for _, line := range []string{
+		`epaxos_preaccept_decisions_total{path="fast"} 2`,
+		`epaxos_preaccept_decisions_total{path="slow"} 0`,
+		`epaxos_prepares_total 0`,
+		`epaxos_instances{status="Committed"} `,
+		`epaxos_execution_lag{row="0"} `,
+		`epaxos_scc_size_count `,
+		`epaxos_transport_messages_total{direction="sent"} `,
+	} {
+		assert.True(t, strings.Contains(text, line), line)
+	}
+}
+
+// The instance counts and the lag only cover instances not executed yet.
+func TestInstanceMetrics(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	for id, status := range []uint8{committed, committed, accepted, preAccepted} {
+		i := NewInstance(r, 1, uint64(id+1))
+		i.status = status
+		r.InstanceMatrix[1][id+1] = i
+	}
+	r.MaxInstanceNum[1] = 4
+	r.ExecutedUpTo[1] = 1
+	r.InstanceMatrix[1][1].executed = true
+
+	assert.Equal(t, r.countInstances(committed), 1)
+	assert.Equal(t, r.countInstances(accepted), 1)
+	assert.Equal(t, r.countInstances(preparing), 0)
+	assert.Equal(t, r.executionLag(1), uint64(3))
+	assert.Equal(t, r.executionLag(2), uint64(0))
+}
@@ -0,0 +1,97 @@
+package replica
+
+// This file implements the admin operations, served with the admin api
+// if EnableAdmin is set. They only accept POST:
+// - /admin/recover/<row>/<id>: starts the recovery of an instance.
+// - /admin/snapshot: replies with a snapshot of the state machine, if it's
+// - a SnapshotStateMachine.
+// @decision(10/18/26):
+// - There is no compaction, the executed instances are never truncated.
+// - The state machines without an applied index replay them after a
+// - restart, and lagging peers may still recover them, so truncating
+// - needs snapshots tied to the applied instances, and peers to catch up
+// - from a snapshot. Neither exists yet.
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-distributed/epaxos"
+)
+
+var (
+	ErrSnapshotUnsupported = errors.New("The state machine doesn't support snapshots")
+)
+
+func (r *Replica) initOperations() {
+	r.httpMux.HandleFunc("/admin/recover/", postOnly(r.serveRecover))
+	r.httpMux.HandleFunc("/admin/snapshot", postOnly(r.serveSnapshot))
+}
+
+// postOnly rejects the requests which are not POST.
+func postOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "POST only", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, req)
+	}
+}
+
+// Snapshot writes a snapshot of the state machine.
+func (r *Replica) Snapshot(w io.Writer) error {
+	sm, ok := r.StateMachine.(epaxos.SnapshotStateMachine)
+	if !ok {
+		return ErrSnapshotUnsupported
+	}
+	return sm.Snapshot(w)
+}
+
+// serveRecover serves /admin/recover/<row>/<id>.
+func (r *Replica) serveRecover(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/admin/recover/"), "/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, req)
+		return
+	}
+	row, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil {
+		http.Error(w, "invalid row", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid instance id", http.StatusBadRequest)
+		return
+	}
+
+	switch err := r.Recover(uint8(row), id); err {
+	case nil:
+		w.WriteHeader(http.StatusAccepted)
+	case ErrStopped:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// serveSnapshot serves /admin/snapshot, the snapshot is buffered
+// so a failure is reported as an error instead of a partial body.
+func (r *Replica) serveSnapshot(w http.ResponseWriter, req *http.Request) {
+	buf := new(bytes.Buffer)
+	switch err := r.Snapshot(buf); err {
+	case nil:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(buf.Bytes())
+	case ErrSnapshotUnsupported:
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,40 @@
+package replica
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/statemachine"
+	"github.com/stretchr/testify/assert"
+)
+
+func operationsTestlibPost(r *Replica, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+	return w
+}
+
+func TestRecoverOperation(t *testing.T) {
+	r := adminTestlibReplica()
+	adminTestlibAdd(r, 1, 1, preAccepted, message.Dependencies{0, 0, 0, 0, 0})
+
+	assert.Equal(t, operationsTestlibPost(r, "/admin/recover/1/1").Code, 202)
+	assert.Equal(t, len(r.requests), 1)
+	assert.Equal(t, operationsTestlibPost(r, "/admin/recover/0/1").Code, 400) // proposed later
+	assert.Equal(t, operationsTestlibPost(r, "/admin/recover/x/1").Code, 400)
+	assert.Equal(t, operationsTestlibPost(r, "/admin/recover/1").Code, 404)
+	assert.Equal(t, adminTestlibGet(r, "/admin/recover/1/1", nil), 405)
+}
+
+func TestSnapshotOperation(t *testing.T) {
+	r := adminTestlibReplica()
+	assert.Equal(t, operationsTestlibPost(r, "/admin/snapshot").Code, 501)
+
+	kv := statemachine.NewKV()
+	kv.Execute([]message.Command{message.Command("put a 1")})
+	r.StateMachine = kv
+	w := operationsTestlibPost(r, "/admin/snapshot")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Body.String(), `{"a":"1"}`+"\n")
+}
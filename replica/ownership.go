@@ -0,0 +1,71 @@
+package replica
+
+// This file implements the key ownership, with an OwnedStateMachine.
+// The keys owned by a replica are expected to be proposed by it only. When
+// it proposes commands on its own keys, it doesn't look for conflicts in
+// other instance spaces, except the ones where it has seen an instance of
+// another replica touching its keys (a foreign instance), and only depends
+// on the latest checkpoint there.
+// The receivers of the pre-accept still look for conflicts everywhere. So
+// without foreign instances, their replies are the same and the instance is
+// committed on the fast path. Otherwise they add the conflicts, and the
+// instance goes through the slow path like any other.
+// @decision(10/18/26):
+// - A majority of pre-accept-oks is not enough to commit, even for owned keys.
+// - A recovery couldn't tell such an instance from one that is only
+// - pre-accepted, and might commit it with other deps.
+// - A foreign instance below the latest checkpoint of its instance space is
+// - covered by the dependency on the checkpoint.
+// - The request is re-scoped to the conflict scan only, the Multi-Paxos-like
+// - commit path is not implemented. Owned instances still go through
+// - pre-accept, and are committed on the EPaxos fast path when no foreign
+// - instance touches the keys.
+// - Sending an accept with the owner's deps right away would need every
+// - receiver, also of the accepts of a recovery, to reject deps missing a
+// - foreign instance it knows, and the fallback on contention to go through
+// - a prepare, since the deps may already be accepted by a majority.
+
+import (
+	"github.com/go-distributed/epaxos/message"
+)
+
+// ownsAll returns true if all keys of cmds are owned by this replica.
+// A no-op conflicts with everything, so it's never owned.
+func (r *Replica) ownsAll(cmds message.Commands) bool {
+	if r.ownedSM == nil || cmds == nil {
+		return false
+	}
+	for _, cmd := range cmds {
+		for _, k := range r.ownedSM.ConflictKeys(cmd) {
+			if r.ownedSM.Owner(k.Key) != r.Id {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// recordForeign records the instance if it's a foreign instance, i.e. an
+// instance of another replica touching the keys of this replica.
+func (r *Replica) recordForeign(i *Instance) {
+	if r.ownedSM == nil || i.rowId == r.Id || i.cmds == nil {
+		return
+	}
+	if i.id <= r.foreignUpTo[i.rowId] {
+		return
+	}
+	for _, cmd := range i.cmds {
+		for _, k := range r.ownedSM.ConflictKeys(cmd) {
+			if r.ownedSM.Owner(k.Key) == r.Id {
+				r.foreignUpTo[i.rowId] = i.id
+				return
+			}
+		}
+	}
+}
+
+// contended returns true if there are foreign instances in instance space
+// rowId after its latest checkpoint up to start.
+func (r *Replica) contended(rowId uint8, start uint64) bool {
+	return r.foreignUpTo[rowId] > start-start%r.CheckpointCycle
+}
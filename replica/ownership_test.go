@@ -0,0 +1,119 @@
+package replica
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/test"
+	"github.com/go-distributed/epaxos/transporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func ownershipTestlibReplica() *Replica {
+	r, err := New(&Param{
+		ReplicaId:       0,
+		Size:            3,
+		CheckpointCycle: 16,
+		StateMachine:    test.NewDummyOwnedKVSM(3),
+		Transporter:     transporter.NewDummyTR(0, 3),
+		EnableOwnership: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func ownershipTestlibAdd(r *Replica, row uint8, id uint64, cmd string) {
+	inst := NewInstance(r, row, id)
+	inst.cmds = message.Commands{message.Command(cmd)}
+	r.InstanceMatrix[row][id] = inst
+	r.indexInstance(inst)
+	if r.MaxInstanceNum[row] < id {
+		r.MaxInstanceNum[row] = id
+	}
+}
+
+func TestOwnsAll(t *testing.T) {
+	r := ownershipTestlibReplica()
+
+	assert.True(t, r.ownsAll(message.Commands{
+		message.Command("put 0a 1"),
+		message.Command("get 0b"),
+	}))
+	assert.False(t, r.ownsAll(message.Commands{
+		message.Command("put 0a 1"),
+		message.Command("get 1b"),
+	}))
+	assert.False(t, r.ownsAll(nil))
+
+	// disabled
+	r.ownedSM = nil
+	assert.False(t, r.ownsAll(message.Commands{message.Command("put 0a 1")}))
+}
+
+// The owner should only depend on its own instance space and the
+// checkpoints, unless another replica touched its keys.
+func TestInitInstanceOwned(t *testing.T) {
+	r := ownershipTestlibReplica()
+	ownershipTestlibAdd(r, 1, 3, "put 1a 1")
+	ownershipTestlibAdd(r, 2, 5, "put 0a 1") // foreign
+	ownershipTestlibAdd(r, 2, 6, "put 2a 1")
+	assert.Equal(t, r.foreignUpTo, []uint64{0, 0, 5})
+
+	i := NewInstance(r, r.Id, 1)
+	r.initInstance(message.Commands{message.Command("put 0a 2")}, i)
+	assert.Equal(t, i.deps, message.Dependencies{0, 0, 5})
+
+	// not owned
+	i = NewInstance(r, r.Id, 2)
+	r.initInstance(message.Commands{message.Command("put 1a 2")}, i)
+	assert.Equal(t, i.deps, message.Dependencies{1, 3, 0})
+
+	// the foreign instance is behind the checkpoint
+	ownershipTestlibAdd(r, 2, 17, "put 2a 2")
+	i = NewInstance(r, r.Id, 3)
+	r.initInstance(message.Commands{message.Command("put 0b 2")}, i)
+	assert.Equal(t, i.deps, message.Dependencies{2, 0, 16})
+}
+
+// Proposals on owned keys should commit on the fast path, and
+// proposals of other replicas on them should still be ordered.
+func TestProposeOwned(t *testing.T) {
+	size := 3
+	nodes := make([]*Replica, size)
+	chs := make([]chan message.Message, size)
+	for i := range nodes {
+		r, err := New(&Param{
+			ReplicaId:       uint8(i),
+			Size:            uint8(size),
+			TimeoutInterval: time.Second * 50, // disable timeout
+			StateMachine:    test.NewDummyOwnedKVSM(uint8(size)),
+			Transporter:     transporter.NewDummyTR(uint8(i), size),
+			EnableOwnership: true,
+		})
+		assert.NoError(t, err)
+		nodes[i] = r
+		chs[i] = r.MessageChan
+	}
+	for _, r := range nodes {
+		r.Transporter.(*transporter.DummyTransporter).RegisterChannels(chs)
+		r.Start()
+		defer r.Stop()
+	}
+
+	results, err := nodes[0].ProposeAndWait(message.Command("put 0a 1"))
+	assert.NoError(t, err)
+	assert.Equal(t, results, []interface{}{"put 0a 1"})
+
+	results, err = nodes[1].ProposeAndWait(message.Command("put 0a 2"))
+	assert.NoError(t, err)
+	assert.Equal(t, results, []interface{}{"put 0a 2"})
+	assert.Equal(t, nodes[1].InstanceMatrix[1][1].deps, message.Dependencies{1, 0, 0})
+
+	results, err = nodes[0].ProposeAndWait(message.Command("put 0a 3"))
+	assert.NoError(t, err)
+	assert.Equal(t, results, []interface{}{"put 0a 3"})
+	assert.Equal(t, nodes[0].InstanceMatrix[0][2].deps, message.Dependencies{1, 1, 0})
+}
@@ -21,6 +21,9 @@ func init() {
 	registerMsgType(message.PrepareMsg, message.Prepare{})
 	registerMsgType(message.PrepareReplyMsg, message.PrepareReply{})
 	registerMsgType(message.TimeoutMsg, message.Timeout{})
+	registerMsgType(message.DigestMsg, message.Digest{})
+	registerMsgType(message.ForwardMsg, message.Forward{})
+	registerMsgType(message.ForwardReplyMsg, message.ForwardReply{})
 }
 
 func registerMsgType(typ uint8, msg interface{}) {
@@ -13,30 +13,27 @@ package replica
 
 import (
 	"bytes"
-	"container/list"
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"net/http"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/logger"
 	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/metrics"
 	"github.com/go-distributed/epaxos/persistent"
-	"github.com/golang/glog"
 )
 
-// #if test
-var v1Log = glog.V(0)
-var v2Log = glog.V(0)
-
-// #else
-// var v1Log = glog.V(1)
-// var v2Log = glog.V(2)
-// #end
-
 var (
-	errConflictsNotFullyResolved = errors.New("Conflicts not fully resolved")
+	ErrInvalidInstance = errors.New("Invalid instance")
+	ErrProposalLost    = errors.New("Proposal lost in recovery")
+	ErrStopped         = errors.New("Replica stopped")
+	ErrCanceled        = errors.New("Proposal canceled")
+	ErrForwardTimeout  = errors.New("Forward timed out")
 )
 
 // ****************************
@@ -48,6 +45,14 @@ const (
 	epochStart             = 1
 )
 
+// event queues
+const (
+	defaultQueueLength = 1024
+	// timeouts are sent in bursts, this bounds the number of
+	// prepare rounds started at once when the replica is idle again
+	defaultTimeoutQueueLength = 256
+)
+
 // actions
 const (
 	noAction uint8 = iota + 1
@@ -57,11 +62,23 @@ const (
 )
 
 const (
-	defaultClusterSize     = 3
-	defaultCheckpointCycle = 1024
-	defaultBatchInterval   = time.Millisecond * 50
-	defaultTimeoutInterval = time.Millisecond * 50
-	defaultExecuteInterval = time.Millisecond * 50
+	defaultClusterSize      = 3
+	defaultCheckpointCycle  = 1024
+	defaultBatchInterval    = time.Millisecond * 50
+	defaultTimeoutInterval  = time.Millisecond * 50
+	defaultExecuteInterval  = time.Millisecond * 50
+	defaultExecuteWorkers   = 4
+	defaultDigestInterval   = time.Millisecond * 100
+	defaultBatchMaxCommands = 1024
+	defaultBatchMaxBytes    = 4096 // fits in an udp packet
+	defaultMaxOutstanding   = 16
+)
+
+// tracing
+const (
+	defaultTraceSlowThreshold = time.Millisecond * 100
+	defaultTraceBufferLength  = 256 // slow traces kept
+	defaultSlowTraces         = 16  // slow traces served by default
 )
 
 const defaultStartPort = 8080
@@ -96,26 +113,118 @@ type Replica struct {
 	Addrs           []string
 	Transporter     epaxos.Transporter
 
+	// lock guards the instances and the replica state above. The event
+	// loop holds it while handling an event, and the executor while it
+	// resolves instances and marks them executed, but not while the state
+	// machine executes their commands. Other goroutines read under it.
+	lock sync.Mutex
+
+	// logger with the replica id field
+	logger logger.Logger
+
+	// conflict index, only for keyed state machine
+	conflictIndex *conflictIndex
+
+	// key ownership, only for owned state machine
+	ownedSM     epaxos.OwnedStateMachine
+	foreignUpTo []uint64 // the highest foreign instance in each row
+
+	// concurrent execution
+	concurrentExecution bool
+	executeWorkers      int
+
+	// error handling
+	errorPolicy  ErrorPolicy
+	onFatalError func(err error)
+	errLock      sync.Mutex
+	err          error // the fatal error which stopped the execution
+
+	feed *executionFeed // nil if the execution feed is disabled
+
+	// execution digests
+	enableDigest   bool
+	digestLock     sync.Mutex
+	rowHash        []uint64 // rolling hash of executed instances in each row
+	rowHashValid   []bool   // false after restoring, until the next checkpoint
+	hashedUpTo     []uint64
+	digestSentUpTo []uint64
+	divergence     *Divergence
+
+	// forwarding
+	enableForwarding bool
+	forwardLock      sync.Mutex
+	quorumLost       bool
+	lastHeard        []time.Time
+	nextForwardId    uint64
+	pendingForwards  map[uint64]*pendingForward
+	forwardedIn      map[forwardKey]*forwardedIn
+	forwardedOrder   []forwardKey
+
 	// tarjan SCC
-	sccStack   *list.List
+	sccStack   []*Instance
+	sccFrames  []sccFrame
 	sccResults [][]*Instance
 	sccIndex   int
+	sccBlocker instanceRef // the instance that stopped the last resolution
+	// blockedOn[i] is the instance that stopped the last resolution
+	// started from instance space i. Until it is committed, resolving
+	// again would walk the same graph and stop at the same place, so the
+	// row is skipped.
+	blockedOn []instanceRef
+	// suspended are the resolutions stopped by a blocker, by their root.
+	// Once the blocker is committed, they go on from where they stopped.
+	suspended map[instanceRef]*sccResolution
 
 	// tickers
 	executeTicker *time.Ticker
 	timeoutTicker *time.Ticker
 	proposeTicker *time.Ticker
+	digestTicker  *time.Ticker
 
 	// triggers
-	executeTrigger chan bool
+	executeTrigger    chan bool      // find and execute in all rows
+	executeCandidates chan *Instance // committed instances to execute
+	executePolling    bool
 
 	// controllers
 	enableBatching bool
 	stop           chan struct{}
 
+	// adaptive batching
+	enableAdaptiveBatching bool
+	batchMaxCommands       int
+	batchMaxBytes          int
+	maxOutstanding         int
+
+	// event queues, in the order of their priorities
+	replies  chan message.Message // replies from peers
+	requests chan message.Message // requests from peers
+	timeouts chan message.Message // dropped when full
+
+	// propose window
+	proposals        chan *pendingProposal
+	proposeWindow    int // 0 if not bounded
+	windowLock       sync.Mutex
+	outstandingCount int
+	windowStats      WindowStats
+	slotFreed        chan struct{}
+
 	// persistent store
 	enablePersistent bool
 	store            *persistent.LevelDB
+
+	// lifecycle tracing
+	enableTracing bool
+	traces        *traces
+
+	// metrics and http endpoint
+	registry        *metrics.Registry
+	metrics         replicaMetrics
+	httpAddr        string
+	enableAdmin     bool
+	enableClientAPI bool
+	httpMux         *http.ServeMux
+	httpServer      *http.Server
 }
 
 type Param struct {
@@ -132,11 +241,90 @@ type Param struct {
 	EnablePersistent bool
 	Restore          bool
 	PersistentPath   string
+	// ExecuteWorkers is the number of goroutines executing independent sccs,
+	// only used if the state machine is a ConcurrentStateMachine.
+	ExecuteWorkers int
+	// ExecutePolling makes the replica look for committed instances every
+	// ExecuteInterval, instead of executing them when they are committed.
+	ExecutePolling bool
+	// ErrorPolicy decides which state machine errors are deterministic,
+	// DefaultErrorPolicy is used if it's nil.
+	ErrorPolicy ErrorPolicy
+	// OnFatalError is called when the execution is stopped by an error.
+	OnFatalError func(err error)
+	// EnableExecutionFeed allows subscribing to executed batches,
+	// they are kept in the store if EnablePersistent is also set.
+	// FeedRetention is the number of batches kept, 65536 if it's 0.
+	EnableExecutionFeed bool
+	FeedRetention       uint64
+	// EnableDigest makes the replica exchange hashes of executed commands
+	// and results every DigestInterval, to detect nondeterministic execution.
+	EnableDigest   bool
+	DigestInterval time.Duration
+	// EnableForwarding makes the replica forward proposals to a peer
+	// when its own proposals time out, because it can't reach a quorum.
+	// Only proposals waiting for their results, e.g. ProposeAndWait,
+	// are forwarded.
+	EnableForwarding bool
+	// EnableAdaptiveBatching makes the replica propose a batch whenever
+	// less than MaxOutstanding of its instances are not committed, with
+	// at most BatchMaxCommands commands and BatchMaxBytes bytes.
+	// BatchInterval is not used then.
+	EnableAdaptiveBatching bool
+	BatchMaxCommands       int
+	BatchMaxBytes          int
+	MaxOutstanding         int
+	// ProposeWindow is the max number of instances of this replica
+	// proposed but not committed yet, 0 means no bound.
+	ProposeWindow int
+	// EnableOwnership makes the replica skip looking for conflicts in other
+	// instance spaces when it proposes commands on its own keys, only used
+	// if the state machine is an OwnedStateMachine.
+	EnableOwnership bool
+	// HTTPAddr is the address serving the metrics, in the prometheus
+	// text format on /metrics. Nothing is served if it's empty.
+	HTTPAddr string
+	// Logger is used for all messages of the replica, a glog logger
+	// honoring the -v flag is used if it's nil.
+	Logger logger.Logger
+	// EnableTracing makes instances record the time of their status
+	// transitions, the executed instances which took longer than
+	// TraceSlowThreshold are served on /traces/slow.
+	EnableTracing      bool
+	TraceSlowThreshold time.Duration
+	// EnableAdmin serves the admin api on /admin/,
+	// see admin.go and operations.go.
+	EnableAdmin bool
+	// EnableClientAPI serves the client api on /client/, see client.go.
+	EnableClientAPI bool
 }
 
-type proposeRequest struct {
+// ErrorPolicy returns true if an error returned by the state machine is
+// deterministic, i.e. every replica gets the same error executing the same
+// commands. Such errors are recorded as results of the commands and the
+// execution continues, other errors stop the execution.
+type ErrorPolicy func(err error) bool
+
+// DefaultErrorPolicy only treats *epaxos.CommandError as deterministic.
+func DefaultErrorPolicy(err error) bool {
+	_, ok := err.(*epaxos.CommandError)
+	return ok
+}
+
+// pendingProposal is a proposal sent to the event loop, with the
+// requests to send back the id of the instance once it's created.
+type pendingProposal struct {
 	cmds message.Commands
-	id   chan uint64
+	reqs []*proposeRequest
+}
+
+type proposeRequest struct {
+	cmds   message.Commands
+	noop   bool
+	id     chan uint64
+	done   chan *proposeResult // nil if the results are not waited for
+	offset int                 // offset of cmds in the proposed batch
+	path   []uint8             // replicas which forwarded the cmds
 }
 
 func newProposeRequest(command ...message.Command) *proposeRequest {
@@ -167,6 +355,30 @@ func verifyparam(param *Param) error {
 	if param.ExecuteInterval == 0 {
 		param.ExecuteInterval = defaultExecuteInterval
 	}
+	if param.ExecuteWorkers == 0 {
+		param.ExecuteWorkers = defaultExecuteWorkers
+	}
+	if param.BatchMaxCommands == 0 {
+		param.BatchMaxCommands = defaultBatchMaxCommands
+	}
+	if param.BatchMaxBytes == 0 {
+		param.BatchMaxBytes = defaultBatchMaxBytes
+	}
+	if param.MaxOutstanding == 0 {
+		param.MaxOutstanding = defaultMaxOutstanding
+	}
+	if param.DigestInterval == 0 {
+		param.DigestInterval = defaultDigestInterval
+	}
+	if param.TraceSlowThreshold == 0 {
+		param.TraceSlowThreshold = defaultTraceSlowThreshold
+	}
+	if param.Logger == nil {
+		param.Logger = logger.NewGlog(nil)
+	}
+	if param.ErrorPolicy == nil {
+		param.ErrorPolicy = DefaultErrorPolicy
+	}
 	if param.Addrs == nil {
 		param.Addrs = make([]string, param.Size)
 		for i := 0; i < int(param.Size); i++ {
@@ -199,17 +411,55 @@ func New(param *Param) (*Replica, error) {
 		StateMachine:    param.StateMachine,
 		Epoch:           epochStart,
 		MessageChan:     make(chan message.Message, 1024),
-		sccStack:        list.New(),
+		sccStack:        make([]*Instance, 0),
+		sccFrames:       make([]sccFrame, 0),
+		blockedOn:       make([]instanceRef, param.Size),
+		suspended:       make(map[instanceRef]*sccResolution),
 		Addrs:           param.Addrs,
 		Transporter:     param.Transporter,
+		logger:          param.Logger.With(logger.Fields{"replica": param.ReplicaId}),
 
-		executeTicker: time.NewTicker(param.ExecuteInterval),
 		timeoutTicker: time.NewTicker(param.TimeoutInterval),
 
-		executeTrigger:   make(chan bool),
-		stop:             make(chan struct{}),
-		enableBatching:   param.EnableBatching,
-		enablePersistent: param.EnablePersistent,
+		executeTrigger:    make(chan bool, 1),
+		executeCandidates: make(chan *Instance, 1024),
+		executePolling:    param.ExecutePolling,
+		errorPolicy:       param.ErrorPolicy,
+		onFatalError:      param.OnFatalError,
+		stop:              make(chan struct{}),
+		enableBatching:    param.EnableBatching && !param.EnableAdaptiveBatching,
+		enablePersistent:  param.EnablePersistent,
+
+		enableDigest:   param.EnableDigest,
+		rowHash:        make([]uint64, param.Size),
+		rowHashValid:   make([]bool, param.Size),
+		hashedUpTo:     make([]uint64, param.Size),
+		digestSentUpTo: make([]uint64, param.Size),
+
+		enableAdaptiveBatching: param.EnableAdaptiveBatching,
+		batchMaxCommands:       param.BatchMaxCommands,
+		batchMaxBytes:          param.BatchMaxBytes,
+		maxOutstanding:         param.MaxOutstanding,
+
+		replies:  make(chan message.Message, defaultQueueLength),
+		requests: make(chan message.Message, defaultQueueLength),
+		timeouts: make(chan message.Message, defaultTimeoutQueueLength),
+
+		proposals:     make(chan *pendingProposal, defaultQueueLength),
+		proposeWindow: param.ProposeWindow,
+		slotFreed:     make(chan struct{}, 1),
+
+		enableForwarding: param.EnableForwarding,
+		lastHeard:        make([]time.Time, param.Size),
+		pendingForwards:  make(map[uint64]*pendingForward),
+		forwardedIn:      make(map[forwardKey]*forwardedIn),
+
+		enableTracing: param.EnableTracing,
+		traces:        newTraces(param.TraceSlowThreshold, defaultTraceBufferLength),
+
+		httpAddr:        param.HTTPAddr,
+		enableAdmin:     param.EnableAdmin,
+		enableClientAPI: param.EnableClientAPI,
 	}
 
 	var path string
@@ -221,52 +471,103 @@ func New(param *Param) (*Replica, error) {
 
 	r.store, err = persistent.NewLevelDB(path, param.Restore)
 	if err != nil {
-		glog.Errorln("replica.New: failed to make new storage")
+		r.logger.Error("failed to make new storage", logger.Fields{"err": err})
 		return nil, err
 	}
 
+	if param.EnableExecutionFeed {
+		if r.enablePersistent {
+			r.feed = newExecutionFeed(r.Id, r.store, param.FeedRetention, r.logger)
+		} else {
+			r.feed = newExecutionFeed(r.Id, nil, param.FeedRetention, r.logger)
+		}
+	}
+
+	if sm, ok := param.StateMachine.(epaxos.KeyedStateMachine); ok {
+		r.conflictIndex = newConflictIndex(sm, param.Size, param.CheckpointCycle)
+	}
+	if sm, ok := param.StateMachine.(epaxos.OwnedStateMachine); ok && param.EnableOwnership {
+		r.ownedSM = sm
+		r.foreignUpTo = make([]uint64, param.Size)
+	}
+	if _, ok := param.StateMachine.(epaxos.ConcurrentStateMachine); ok {
+		r.concurrentExecution = true
+		r.executeWorkers = param.ExecuteWorkers
+	}
+
 	for i := uint8(0); i < param.Size; i++ {
 		r.InstanceMatrix[i] = make([]*Instance, defaultInstancesLength)
 		r.MaxInstanceNum[i] = conflictNotFound
 		r.ExecutedUpTo[i] = conflictNotFound
+		r.rowHashValid[i] = true
 	}
 
 	// restore replica and instances
 	if param.Restore {
 		err := r.RecoverFromPersistent()
 		if err != nil {
-			glog.Errorln("Recover from persistent failed!")
+			r.logger.Error("failed to recover from persistent", logger.Fields{"err": err})
 			return nil, err
 		}
 	}
 
 	r.Transporter.RegisterChannel(r.MessageChan)
+	r.initMetrics()
+	r.initHTTP()
 
 	if r.enableBatching {
 		r.proposeTicker = time.NewTicker(param.BatchInterval)
 	}
+	if r.executePolling {
+		r.executeTicker = time.NewTicker(param.ExecuteInterval)
+	}
+	if r.enableDigest {
+		r.digestTicker = time.NewTicker(param.DigestInterval)
+	}
 
 	return r, nil
 }
 
 // Start running the replica. It shouldn't stop at any time.
 func (r *Replica) Start() error {
+	go r.receiveLoop()
 	go r.eventLoop()
 	go r.executeLoop()
 	go r.proposeLoop()
 	go r.timeoutLoop()
+	if r.enableDigest {
+		go r.digestLoop()
+	}
+	if r.httpAddr != "" {
+		if err := r.startHTTP(); err != nil {
+			return err
+		}
+	}
 	return r.Transporter.Start()
 }
 
 func (r *Replica) stopTickers() {
-	r.executeTicker.Stop()
+	if r.executeTicker != nil {
+		r.executeTicker.Stop()
+	}
 	r.timeoutTicker.Stop()
-	r.proposeTicker.Stop()
+	if r.proposeTicker != nil {
+		r.proposeTicker.Stop()
+	}
+	if r.digestTicker != nil {
+		r.digestTicker.Stop()
+	}
 }
 
+// Stop the replica. The http endpoint is stopped first, so the client
+// proposals in flight can still be executed.
 func (r *Replica) Stop() {
+	r.stopHTTP()
 	close(r.stop)
 	r.stopTickers()
+	if r.feed != nil {
+		r.feed.close()
+	}
 	r.Transporter.Stop()
 	r.store.Close()
 }
@@ -278,11 +579,17 @@ func (r *Replica) timeoutLoop() {
 			return
 		case <-r.timeoutTicker.C:
 			r.checkTimeout()
+			if r.enableForwarding {
+				r.resendForwards()
+			}
 		}
 	}
 }
 
 func (r *Replica) checkTimeout() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
 	for i, instance := range r.InstanceMatrix {
 
 		// from executeupto to max, test timestamp,
@@ -292,12 +599,29 @@ func (r *Replica) checkTimeout() {
 				continue
 			}
 			if instance[j] == nil || instance[j].isTimeout() {
-				r.MessageChan <- r.makeTimeout(uint8(i), j)
+				if uint8(i) == r.Id && instance[j] != nil && instance[j].isSender() {
+					// a proposal of this replica is not committed in time
+					r.setQuorumLost(true)
+				}
+				r.sendTimeout(uint8(i), j)
 			}
 		}
 	}
 }
 
+// sendTimeout never blocks, the event loop may be busy. If the timeouts
+// queue is full, the timeout is dropped and sent again on the next check.
+func (r *Replica) sendTimeout(rowId uint8, instanceId uint64) {
+	select {
+	case r.timeouts <- r.makeTimeout(rowId, instanceId):
+	default:
+		r.logger.Info(logger.Timeout, 1, "drop timeout", logger.Fields{
+			"row":      rowId,
+			"instance": instanceId,
+		})
+	}
+}
+
 func (r *Replica) makeTimeout(rowId uint8, instanceId uint64) message.Message {
 	return &message.Timeout{
 		ReplicaId:  rowId,
@@ -306,20 +630,124 @@ func (r *Replica) makeTimeout(rowId uint8, instanceId uint64) message.Message {
 	}
 }
 
+// receiveLoop sorts the messages from the transporter into replies and
+// requests. It blocks when their queue is full, which pushes back on peers.
+func (r *Replica) receiveLoop() {
+	for {
+		var msg message.Message
+		select {
+		case <-r.stop:
+			return
+		case msg = <-r.MessageChan:
+		}
+
+		r.heardFrom(msg.Sender())
+		queue := r.requests
+		if isReply(msg) {
+			queue = r.replies
+		}
+		select {
+		case <-r.stop:
+			return
+		case queue <- msg:
+		}
+	}
+}
+
+func isReply(msg message.Message) bool {
+	switch msg.(type) {
+	case *message.PreAcceptReply, *message.PreAcceptOk,
+		*message.AcceptReply, *message.PrepareReply, *message.ForwardReply:
+		return true
+	}
+	return false
+}
+
 // handling events
-// TODO: differentiate internal and external messages
+// Replies are handled first, since they complete the instances in progress,
+// then requests from peers, new proposals, and timeouts at last.
 func (r *Replica) eventLoop() {
 	for {
+		select {
+		case msg := <-r.replies:
+			r.handleEvent(msg, nil)
+			continue
+		default:
+		}
+
+		select {
+		case msg := <-r.requests:
+			r.handleEvent(msg, nil)
+			continue
+		default:
+		}
+
+		select {
+		case p := <-r.proposals:
+			r.handleEvent(nil, p)
+			continue
+		default:
+		}
+
 		select {
 		case <-r.stop:
 			return
-		case msg := <-r.MessageChan:
-			r.dispatch(msg)
+		case msg := <-r.replies:
+			r.handleEvent(msg, nil)
+		case msg := <-r.requests:
+			r.handleEvent(msg, nil)
+		case p := <-r.proposals:
+			r.handleEvent(nil, p)
+		case msg := <-r.timeouts:
+			r.lock.Lock()
+			if r.timeoutExpired(msg) {
+				r.handleMessage(msg)
+			}
+			r.lock.Unlock()
 		}
 	}
 }
 
+// handleEvent handles a message or a proposal, holding the lock.
+func (r *Replica) handleEvent(msg message.Message, p *pendingProposal) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if p != nil {
+		r.handleProposal(p)
+	} else {
+		r.handleMessage(msg)
+	}
+}
+
+// timeoutExpired returns false if the instance was touched since the
+// timeout was sent, while the timeout was waiting in the queue.
+func (r *Replica) timeoutExpired(msg message.Message) bool {
+	i := r.InstanceMatrix[msg.Replica()][msg.Instance()]
+	return i == nil || i.isTimeout()
+}
+
+func (r *Replica) handleMessage(msg message.Message) {
+	switch m := msg.(type) {
+	case *message.Digest:
+		r.handleDigest(m)
+	case *message.Forward:
+		go r.handleForward(m)
+	case *message.ForwardReply:
+		r.handleForwardReply(m)
+	default:
+		r.dispatch(msg)
+	}
+}
+
 func (r *Replica) executeLoop() {
+	if r.executePolling {
+		r.executeLoopWithPolling()
+	} else {
+		r.executeLoopWithNotification()
+	}
+}
+
+func (r *Replica) executeLoopWithPolling() {
 	for {
 		select {
 		case <-r.stop:
@@ -330,11 +758,76 @@ func (r *Replica) executeLoop() {
 		case <-r.executeTrigger:
 			r.findAndExecute()
 		}
+		if !r.Healthy() {
+			return
+		}
+	}
+}
+
+func (r *Replica) executeLoopWithNotification() {
+	// instances committed before starting, e.g. restored ones
+	r.findAndExecute()
+	for r.Healthy() {
+		select {
+		case <-r.stop:
+			return
+		case i := <-r.executeCandidates:
+			r.executeCandidate(i)
+		case <-r.executeTrigger:
+			r.findAndExecute()
+		}
+	}
+}
+
+// Healthy returns false if the execution is stopped by an error.
+func (r *Replica) Healthy() bool {
+	return r.Err() == nil
+}
+
+// Err returns the error which stopped the execution, if any.
+func (r *Replica) Err() error {
+	r.errLock.Lock()
+	defer r.errLock.Unlock()
+	return r.err
+}
+
+// fail stops the execution with a fatal error.
+func (r *Replica) fail(err error) {
+	r.logger.Error("execution stopped", logger.Fields{"err": err})
+	r.errLock.Lock()
+	r.err = err
+	r.errLock.Unlock()
+	if r.onFatalError != nil {
+		r.onFatalError(err)
+	}
+}
+
+// notifyCommitted is called when an instance is committed. It never blocks,
+// if the candidates queue is full, all rows will be searched instead.
+func (r *Replica) notifyCommitted(i *Instance) {
+	if i.rowId == r.Id {
+		if r.enableForwarding {
+			r.setQuorumLost(false)
+		}
+		r.releaseSlot()
+	}
+	if r.executePolling {
+		return
+	}
+	select {
+	case r.executeCandidates <- i:
+	default:
+		select {
+		case r.executeTrigger <- true:
+		default:
+		}
 	}
 }
 
 func (r *Replica) proposeLoop() {
-	if r.enableBatching {
+	if r.enableAdaptiveBatching {
+		r.proposeLoopAdaptive()
+	} else if r.enableBatching {
 		r.proposeLoopWithBatching()
 	} else {
 		r.proposeLoopWithoutBatching()
@@ -375,6 +868,48 @@ func (r *Replica) Propose(cmds ...message.Command) chan uint64 {
 	return req.id
 }
 
+// ProposeNoop proposes a no-op, which is never batched with other commands.
+// return the channel containing the internal instance id
+func (r *Replica) ProposeNoop() chan uint64 {
+	req := newProposeRequest()
+	req.noop = true
+	r.ProposeChan <- req
+	return req.id
+}
+
+// Recover starts a recovery of the instance from this replica, e.g. when
+// its leader has failed permanently. The instance will be committed with
+// the value known by the other replicas, or a no-op if there is none.
+// It has no effect on committed instances.
+// It returns ErrStopped if the replica is stopped meanwhile.
+func (r *Replica) Recover(rowId uint8, instanceId uint64) error {
+	if !r.recoverable(rowId, instanceId) {
+		return ErrInvalidInstance
+	}
+	// sent as a request, so it's handled even if the instance is active
+	select {
+	case r.requests <- r.makeTimeout(rowId, instanceId):
+		return nil
+	case <-r.stop:
+		return ErrStopped
+	}
+}
+
+// recoverable returns true if the instance can be recovered, the
+// instance matrix may be grown by the event loop, so it holds the lock.
+func (r *Replica) recoverable(rowId uint8, instanceId uint64) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if rowId >= r.Size ||
+		instanceId >= uint64(len(r.InstanceMatrix[rowId])) ||
+		r.IsCheckpoint(instanceId) {
+		return false
+	}
+	// it would be proposed later by this replica
+	return rowId != r.Id || instanceId < r.ProposeNum
+}
+
 // TODO: This must be done in a synchronized/atomic way.
 func (r *Replica) batchPropose(batchedRequests *[]*proposeRequest) {
 	defer func() { *batchedRequests = (*batchedRequests)[:0] }() // resize
@@ -384,15 +919,46 @@ func (r *Replica) batchPropose(batchedRequests *[]*proposeRequest) {
 		return
 	}
 
-	// copy commands
+	// copy commands, no-ops are proposed alone
+	batch := make([]*proposeRequest, 0, len(br))
 	cmds := make([]message.Command, 0)
 	for i := range br {
+		if br[i].noop {
+			r.propose(nil, br[i])
+			continue
+		}
+		br[i].offset = len(cmds)
+		batch = append(batch, br[i])
 		cmds = append(cmds, br[i].cmds...)
 	}
+	if len(batch) > 0 && !r.tryForward(cmds, batch) {
+		r.propose(cmds, batch...)
+	}
+}
+
+// propose proposes cmds in a new instance, once there
+// is a free slot in the propose window.
+func (r *Replica) propose(cmds message.Commands, br ...*proposeRequest) {
+	// wait for a free slot in the propose window
+	if !r.acquireSlot() {
+		return
+	}
+
+	// the event loop picks the instance id and sends it back once the
+	// instance is created, so the next proposal doesn't wait for it
+	select {
+	case r.proposals <- &pendingProposal{cmds: cmds, reqs: br}:
+	case <-r.stop:
+	}
+}
 
+// handleProposal creates the proposed instance,
+// and sends back its id to the requests.
+// ProposeNum is only changed here, on the event loop, which also
+// stores the replica when an instance is created.
+func (r *Replica) handleProposal(p *pendingProposal) {
 	// record the current instance id
 	iid := r.ProposeNum
-	proposal := message.NewPropose(r.Id, iid, cmds)
 
 	// update propose num
 	r.ProposeNum++
@@ -401,16 +967,16 @@ func (r *Replica) batchPropose(batchedRequests *[]*proposeRequest) {
 	}
 	r.StoreReplica()
 
-	// TODO: we could use another channel
-	// and with synchronization to improve throughput
-	r.MessageChan <- proposal
+	r.dispatch(message.NewPropose(r.Id, iid, p.cmds))
 
-	// wait for the construction of instance and
-	// send back its id
-	<-proposal.Created
-	for _, req := range br {
+	waiting := false
+	for _, req := range p.reqs {
 		req.id <- iid
 		close(req.id)
+		waiting = waiting || req.done != nil
+	}
+	if waiting {
+		go r.waitResults(r.InstanceMatrix[r.Id][iid], p.reqs)
 	}
 }
 
@@ -425,10 +991,14 @@ func (r *Replica) dispatch(msg message.Message) {
 
 	r.updateMaxInstanceNum(replicaId, instanceId)
 
-	v1Log.Infof("Replica[%v]: recv message[%s], from Replica[%v]\n",
-		r.Id, msg.String(), msg.Sender())
-	if glog.V(0) {
-		printDependencies(msg)
+	if r.logger.V(logger.Dispatch, 1) {
+		fields := logger.Fields{"from": msg.Sender()}
+		if r.logger.V(logger.Dispatch, 2) {
+			if deps := messageDependencies(msg); deps != nil {
+				fields["deps"] = deps
+			}
+		}
+		r.logger.Info(logger.Dispatch, 1, "recv "+msg.String(), fields)
 	}
 
 	if instanceId <= conflictNotFound {
@@ -446,9 +1016,9 @@ func (r *Replica) dispatch(msg message.Message) {
 	i := r.InstanceMatrix[replicaId][instanceId]
 	i.touch() // update last touched timestamp
 
-	v1Log.Infof("Replica[%v]: instance[%v][%v] status before = %v, ballot = [%v]\n",
-		r.Id, replicaId, instanceId, i.StatusString(), i.ballot.String())
-	v2Log.Infof("dependencies before: %v\n", i.Dependencies())
+	if r.logger.V(logger.Dispatch, 1) {
+		r.logger.Info(logger.Dispatch, 1, "status before", i.logFields())
+	}
 
 	var action uint8
 	var rep message.Message
@@ -468,13 +1038,8 @@ func (r *Replica) dispatch(msg message.Message) {
 		panic("")
 	}
 
-	v2Log.Infof("dependencies after: %v\n", i.Dependencies())
-	if action == noAction {
-		v1Log.Infof("Replica[%v]: instance[%v][%v] status after = %v, ballot = [%v]\n\n\n",
-			r.Id, replicaId, instanceId, i.StatusString(), i.ballot.String())
-	} else {
-		v1Log.Infof("Replica[%v]: instance[%v][%v] status after = %v, ballot = [%v]\n",
-			r.Id, replicaId, instanceId, i.StatusString(), i.ballot.String())
+	if r.logger.V(logger.Dispatch, 1) {
+		r.logger.Info(logger.Dispatch, 1, "status after", i.logFields())
 	}
 
 	if r.enablePersistent {
@@ -485,16 +1050,22 @@ func (r *Replica) dispatch(msg message.Message) {
 	case noAction:
 		return
 	case replyAction:
-		v1Log.Infof("Replica[%v]: send message[%s], to Replica[%v]\n\n\n",
-			r.Id, rep.String(), msg.Sender())
+		if r.logger.V(logger.Dispatch, 1) {
+			r.logger.Info(logger.Dispatch, 1, "send "+rep.String(),
+				logger.Fields{"to": msg.Sender()})
+		}
 		r.Transporter.Send(msg.Sender(), rep) // send back to the sender of the message
 	case fastQuorumAction:
-		v1Log.Infof("Replica[%v]: send message[%s], to FastQuorum\n\n\n",
-			r.Id, rep.String())
+		if r.logger.V(logger.Dispatch, 1) {
+			r.logger.Info(logger.Dispatch, 1, "send "+rep.String(),
+				logger.Fields{"to": "fast quorum"})
+		}
 		r.Transporter.MulticastFastquorum(rep)
 	case broadcastAction:
-		v1Log.Infof("Replica[%v]: send message[%s], to Everyone\n\n\n",
-			r.Id, rep.String())
+		if r.logger.V(logger.Dispatch, 1) {
+			r.logger.Info(logger.Dispatch, 1, "send "+rep.String(),
+				logger.Fields{"to": "everyone"})
+		}
 		r.Transporter.Broadcast(rep)
 	default:
 		panic("")
@@ -536,9 +1107,9 @@ func (r *Replica) initInstance(cmds message.Commands, i *Instance) {
 	}
 
 	deps := make(message.Dependencies, r.Size)
+	owned := r.ownsAll(cmds)
 
 	for curr := range r.InstanceMatrix {
-		instances := r.InstanceMatrix[curr]
 		start := r.MaxInstanceNum[curr]
 
 		if curr == int(i.rowId) {
@@ -547,10 +1118,17 @@ func (r *Replica) initInstance(cmds message.Commands, i *Instance) {
 			continue
 		}
 
-		conflict := r.scanConflicts(instances, cmds, start, 0)
+		if owned && !r.contended(uint8(curr), start) {
+			// only the latest checkpoint can conflict
+			deps[curr] = start - start%r.CheckpointCycle
+			continue
+		}
+
+		conflict := r.findConflict(uint8(curr), cmds, start, 0)
 		deps[curr] = conflict
 	}
 	i.cmds, i.deps = cmds.Clone(), deps.Clone()
+	r.indexInstance(i)
 	// we can only update here because
 	// now we are safe to have cmds, etc. inside instance
 	//if !i.replica.updateMaxInstanceNum(i.rowId, i.id) {
@@ -571,10 +1149,9 @@ func (r *Replica) updateInstance(cmds message.Commands, deps message.Dependencie
 			continue
 		}
 
-		instances := r.InstanceMatrix[curr]
 		start, end := r.MaxInstanceNum[curr], deps[curr]
 
-		conflict := r.scanConflicts(instances, cmds, start, end)
+		conflict := r.findConflict(uint8(curr), cmds, start, end)
 		if deps[curr] < conflict {
 			changed = true
 			deps[curr] = conflict
@@ -582,6 +1159,7 @@ func (r *Replica) updateInstance(cmds message.Commands, deps message.Dependencie
 	}
 
 	i.cmds, i.deps = cmds, deps
+	r.indexInstance(i)
 	// we can only update here because
 	// now we are safe to have cmds, etc. inside instance
 	i.replica.updateMaxInstanceNum(i.rowId, i.id)
@@ -592,6 +1170,28 @@ func (r *Replica) IsCheckpoint(n uint64) bool {
 	return n%r.CheckpointCycle == 0
 }
 
+// findConflict returns the highest instance in (end, start] of the instance
+// space that has conflicts with passed in cmds, or end if there is none.
+// It looks up the conflict index if there is one, otherwise scans.
+func (r *Replica) findConflict(rowId uint8, cmds message.Commands, start uint64, end uint64) uint64 {
+	// no-op conflicts with every other command, which the index can't tell
+	if r.conflictIndex == nil || cmds == nil {
+		return r.scanConflicts(r.InstanceMatrix[rowId], cmds, start, end)
+	}
+	return r.conflictIndex.latest(rowId, cmds, start, end)
+}
+
+// indexInstance adds the commands of the instance into the conflict index,
+// and records it if it's a foreign instance.
+// It must be called whenever the commands of an instance are set.
+func (r *Replica) indexInstance(i *Instance) {
+	r.recordForeign(i)
+	if r.conflictIndex == nil || i.cmds == nil {
+		return
+	}
+	r.conflictIndex.add(i.rowId, i.id, i.cmds)
+}
+
 // scanConflicts scans the instances from start to end (high to low).
 // return the highest instance that has conflicts with passed in cmds.
 func (r *Replica) scanConflicts(instances []*Instance, cmds message.Commands, start uint64, end uint64) uint64 {
@@ -630,201 +1230,569 @@ func (r *Replica) updateMaxInstanceNum(rowId uint8, instanceId uint64) bool {
 // ********  EXECUTION **********
 // ******************************
 
+// findAndExecute executes committed instances in all instance spaces.
 func (r *Replica) findAndExecute() {
-	for i := 0; i < int(r.Size); i++ {
-		// search this instance space
-		for {
-			up := r.ExecutedUpTo[i] + 1
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i := 0; i < int(r.Size) && r.Healthy(); i++ {
+		r.executeRow(i)
+	}
+	// a row may have met a suspended resolution which was resumed by a
+	// later row, it's blocked on an instance committed by now then
+	for i := 0; i < int(r.Size) && r.Healthy(); i++ {
+		if r.blockedOn[i].id != conflictNotFound && !r.isBlocked(i) {
+			r.executeRow(i)
+		}
+	}
+}
 
-			if r.IsCheckpoint(up) {
-				r.ExecutedUpTo[i]++
-				continue
-			}
+// executeCandidate resumes execution in the instance space of the
+// committed instance, and in the ones blocked on it.
+func (r *Replica) executeCandidate(instance *Instance) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	committedRef := instanceRef{instance.rowId, instance.id}
+	for i := 0; i < int(r.Size) && r.Healthy(); i++ {
+		if i == int(instance.rowId) || r.blockedOn[i] == committedRef {
+			r.executeRow(i)
+		}
+	}
+	// the rows which met the resolution suspended on the instance
+	// before it was resumed, see findAndExecute
+	for i := 0; i < int(r.Size) && r.Healthy(); i++ {
+		if r.blockedOn[i] == committedRef {
+			r.executeRow(i)
+		}
+	}
+}
 
-			instance := r.InstanceMatrix[i][up]
+// executeRow executes committed instances in instance space i,
+// from ExecutedUpTo until it meets an instance that can't be executed.
+// It's called holding the lock.
+func (r *Replica) executeRow(i int) {
+	if r.enableDigest {
+		defer r.updateDigest(i)
+	}
+	// search this instance space
+	for {
+		up := r.ExecutedUpTo[i] + 1
 
-			// [*] if the instance is nil, then we should not continue to execute,
-			// because this instance maybe already commited and executed by other
-			// replicas
-			if instance == nil {
-				break
-			}
-			if !instance.isAtStatus(committed) {
-				break
-			}
-			if instance.isExecuted() {
-				r.ExecutedUpTo[i]++
-				continue
-			}
-			if err := r.execute(instance); err != nil {
-				switch err {
-				case errConflictsNotFullyResolved:
-					break
-				case epaxos.ErrStateMachineExecution:
-					panic("")
-					// TODO: log and warning
-					break
-				default:
-					panic("unexpected error")
-				}
-			}
+		if r.IsCheckpoint(up) {
+			r.ExecutedUpTo[i]++
+			continue
+		}
+
+		instance := r.InstanceMatrix[i][up]
+
+		// [*] if the instance is nil, then we should not continue to execute,
+		// because this instance maybe already commited and executed by other
+		// replicas
+		if instance == nil {
+			break
+		}
+		if !instance.isAtStatus(committed) {
+			break
+		}
+		if instance.isExecuted() {
+			r.ExecutedUpTo[i]++
+			continue
+		}
+		if r.isBlocked(i) {
+			break
+		}
+		if err := r.execute(instance); err != nil {
+			r.fail(err)
+			return
+		}
+		if r.blockedOn[i].id != conflictNotFound {
+			// the blocker may be committed already, if the resolution
+			// met a suspended one, which is resumed by its own row
+			break
 		}
 	}
 }
 
-// NOTE: atomic
+// execute resolves and executes the instance and the ones it depends on.
+// It's called holding the lock.
 func (r *Replica) execute(i *Instance) error {
-	r.sccStack.Init()
 	r.sccResults = make([][]*Instance, 0)
-	r.sccIndex = 1
+	if len(r.suspended) == 0 {
+		// the indexes are only compared on the stacks
+		r.sccIndex = 1
+	}
 
-	v2Log.Infoln("start resolve")
+	r.logger.Info(logger.Execute, 2, "start resolve", nil)
 	if ok := r.resolveConflicts(i); !ok {
-		v2Log.Infoln("there is incomplete scc")
+		r.logger.Info(logger.Execute, 2, "there is incomplete scc", logger.Fields{
+			"blocker_row":      r.sccBlocker.rowId,
+			"blocker_instance": r.sccBlocker.id,
+		})
+		r.blockedOn[i.rowId] = r.sccBlocker
 	}
 	// execute elements in the result list
 	// nodes of the list are in order that:
 	// - nodes SCC being dependent are at smaller index than
 	// - - nodes depending on it.
 	// - In the same component, nodes at higher rowId are at smaller index.
-	if err := r.executeList(); err != nil {
-		return err
-	}
-	return nil
+	// The resolved instances are committed, so their commands and deps
+	// don't change, the lock is released while they are executed.
+	r.lock.Unlock()
+	defer r.lock.Lock()
+	return r.executeList()
 }
 
 // this should be a transaction.
 func (r *Replica) executeList() error {
+	for _, sccNodes := range r.sccResults {
+		r.metrics.sccSizes.Observe(float64(len(sccNodes)))
+	}
+	if r.concurrentExecution && len(r.sccResults) > 1 {
+		return r.executeListConcurrently()
+	}
+
 	cmdsBuffer := make([]message.Command, 0)
 
 	// batch all commands in the scc
-	v2Log.Infoln("execute list")
+	r.logger.Info(logger.Execute, 2, "execute list", nil)
 	for _, sccNodes := range r.sccResults {
-		v2Log.Infoln("one scc")
-		for _, instance := range sccNodes {
-			v2Log.Infof("Instance [%v][%v] executed\n", instance.rowId, instance.id)
+		if err := r.executeScc(sccNodes, &cmdsBuffer); err != nil {
+			return err
 		}
-		v2Log.Infoln("scc end")
-		//v2Log.Infoln()
+	}
+	return nil
+}
 
-		cmdsBuffer = cmdsBuffer[:0]
+// executeScc executes the commands of all instances in one scc as a batch,
+// and marks them executed. It's called without the lock, which is only
+// held before and after the state machine executes the commands.
+func (r *Replica) executeScc(sccNodes []*Instance, cmdsBuffer *[]message.Command) error {
+	if r.logger.V(logger.Execute, 2) {
 		for _, instance := range sccNodes {
-			cmdsBuffer = append(cmdsBuffer, instance.cmds...)
+			r.logger.Info(logger.Execute, 2, "executed", logger.Fields{
+				"row":      instance.rowId,
+				"instance": instance.id,
+				"scc":      len(sccNodes),
+			})
 		}
-		// return results from state machine are not being used currently
+	}
 
-		// TODO: the results from statemachine should be relayed to callback
-		//      of some client function
-		_, err := r.StateMachine.Execute(cmdsBuffer)
-		if err != nil {
+	cmds := (*cmdsBuffer)[:0]
+	for _, instance := range sccNodes {
+		cmds = append(cmds, instance.cmds...)
+	}
+	*cmdsBuffer = cmds
+
+	var results []interface{}
+	var err error
+	if sm, ok := r.StateMachine.(epaxos.CheckpointedStateMachine); ok {
+		// the state machine records the applied instances atomically
+		// with the commands, the executed flags below are only a cache.
+		r.lock.Lock()
+		delta := r.appliedDelta(sccNodes)
+		r.lock.Unlock()
+		results, err = sm.ExecuteApplied(cmds, delta)
+	} else {
+		results, err = r.StateMachine.Execute(cmds)
+	}
+	if err != nil {
+		if !r.errorPolicy(err) {
 			return err
 		}
-		// TODO: transaction one
-		for _, instance := range sccNodes {
-			instance.SetExecuted()
+		r.logger.Info(logger.Execute, 1, "commands failed", logger.Fields{"err": err})
+		results = commandResults(err, len(cmds))
+	}
+
+	executedWith := make([]instanceRef, len(sccNodes))
+	for k, instance := range sccNodes {
+		executedWith[k] = instanceRef{instance.rowId, instance.id}
+	}
+
+	r.lock.Lock()
+	published := make([]*Instance, 0, len(sccNodes))
+	for _, instance := range sccNodes {
+		if !instance.replayed {
+			published = append(published, instance)
 		}
-		if r.enablePersistent {
-			r.StoreInstances(sccNodes...)
+		instance.replayed = false
+		instance.executedWith = executedWith
+		n := len(instance.cmds)
+		if len(results) >= n {
+			instance.results = results[:n:n]
+			results = results[n:]
 		}
+		instance.SetExecuted()
+	}
+	if r.enablePersistent {
+		r.StoreInstances(sccNodes...)
+	}
+	r.lock.Unlock()
+
+	// it waits for slow subscribers, so not holding the lock
+	if r.feed != nil && len(published) > 0 {
+		r.publishExecuted(published)
 	}
 	return nil
 }
 
+// commandResults returns the results of n commands failed
+// deterministically with err.
+func commandResults(err error, n int) []interface{} {
+	if cmdErr, ok := err.(*epaxos.CommandError); ok && len(cmdErr.Results) == n {
+		return cmdErr.Results
+	}
+	results := make([]interface{}, n)
+	for i := range results {
+		results[i] = err
+	}
+	return results
+}
+
+// executeListConcurrently executes the sccs in the result list on a pool of
+// workers. An scc is started only after all sccs it depends on are executed,
+// so conflicting commands keep the order of the list, while sccs without
+// any dependency path between them don't conflict and run at the same time.
+// After an error, no more scc is started.
+func (r *Replica) executeListConcurrently() error {
+	sccs := r.sccResults
+
+	// build the dependency DAG of sccs
+	r.lock.Lock()
+	sccOf := make(map[*Instance]int)
+	for n, sccNodes := range sccs {
+		for _, instance := range sccNodes {
+			sccOf[instance] = n
+		}
+	}
+	waiting := make([]int, len(sccs))      // the number of sccs it waits for
+	dependents := make([][]int, len(sccs)) // the sccs waiting for it
+	for n, sccNodes := range sccs {
+		seen := make(map[int]bool)
+		for _, instance := range sccNodes {
+			for iSpace, dep := range instance.deps {
+				if r.IsCheckpoint(dep) {
+					continue
+				}
+				m, ok := sccOf[r.InstanceMatrix[iSpace][dep]]
+				if !ok || m == n || seen[m] {
+					continue
+				}
+				seen[m] = true
+				waiting[n]++
+				dependents[m] = append(dependents[m], n)
+			}
+		}
+	}
+	r.lock.Unlock()
+
+	type sccDone struct {
+		n   int
+		err error
+	}
+	ready := make(chan int, len(sccs))
+	done := make(chan sccDone, len(sccs))
+	defer close(ready)
+
+	workers := r.executeWorkers
+	if workers > len(sccs) {
+		workers = len(sccs)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			cmdsBuffer := make([]message.Command, 0)
+			for n := range ready {
+				done <- sccDone{n, r.executeScc(sccs[n], &cmdsBuffer)}
+			}
+		}()
+	}
+
+	scheduled := 0
+	for n := range sccs {
+		if waiting[n] == 0 {
+			ready <- n
+			scheduled++
+		}
+	}
+
+	var err error
+	for finished := 0; finished < scheduled; finished++ {
+		d := <-done
+		if d.err != nil {
+			if err == nil {
+				err = d.err
+			}
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		for _, m := range dependents[d.n] {
+			waiting[m]--
+			if waiting[m] == 0 {
+				ready <- m
+				scheduled++
+			}
+		}
+	}
+	return err
+}
+
+// appliedDelta returns the applied index to be merged into a checkpointed
+// state machine after executing sccNodes: the contiguous executed prefix of
+// each instance space, plus the instances of the scc.
+func (r *Replica) appliedDelta(sccNodes []*Instance) *epaxos.AppliedIndex {
+	delta := epaxos.NewAppliedIndex(int(r.Size))
+	for row := range delta.UpTo {
+		delta.UpTo[row] = r.executedPrefix(uint8(row))
+	}
+	for _, instance := range sccNodes {
+		delta.Extra[instance.rowId] = append(delta.Extra[instance.rowId], instance.id)
+	}
+	return delta
+}
+
+// executedPrefix returns the highest instance id of the instance space such
+// that every instance up to it is either executed or a checkpoint.
+func (r *Replica) executedPrefix(row uint8) uint64 {
+	up := r.ExecutedUpTo[row]
+	for {
+		next := up + 1
+		if !r.IsCheckpoint(next) {
+			instance := r.InstanceMatrix[row][next]
+			if instance == nil || !instance.isExecuted() {
+				return up
+			}
+		}
+		up = next
+	}
+}
+
+// reconcileExecution makes the executed flags and ExecutedUpTo agree with
+// the applied index of a checkpointed state machine, which is the source of
+// truth after a restart.
+func (r *Replica) reconcileExecution() {
+	sm, ok := r.StateMachine.(epaxos.CheckpointedStateMachine)
+	if !ok {
+		return
+	}
+	applied := sm.AppliedIndex()
+
+	for i := uint8(0); i < r.Size; i++ {
+		for j := uint64(1); j <= r.MaxInstanceNum[i]; j++ {
+			if inst := r.InstanceMatrix[i][j]; inst != nil {
+				// it's already in the execution feed
+				inst.replayed = inst.executed && !applied.Contains(i, j)
+				inst.executed = applied.Contains(i, j)
+			}
+		}
+		r.ExecutedUpTo[i] = conflictNotFound
+		if applied != nil && int(i) < len(applied.UpTo) {
+			r.ExecutedUpTo[i] = applied.UpTo[i]
+		}
+	}
+}
+
+// isBlocked returns true if the last resolution started from instance
+// space i stopped at an instance which is still not committed.
+func (r *Replica) isBlocked(i int) bool {
+	blocker := r.blockedOn[i]
+	if blocker.id == conflictNotFound {
+		return false
+	}
+	instance := r.InstanceMatrix[blocker.rowId][blocker.id]
+	if instance != nil && instance.isAtStatus(committed) {
+		r.blockedOn[i] = instanceRef{}
+		return false
+	}
+	return true
+}
+
+// instanceRef refers to an instance which may not exist yet,
+// the zero value refers to nothing (id 0 is a checkpoint).
+type instanceRef struct {
+	rowId uint8
+	id    uint64
+}
+
+// sccFrame is a node being visited by resolveConflicts,
+// with the next instance space of its deps to look at.
+type sccFrame struct {
+	node   *Instance
+	iSpace int
+}
+
+// sccResolution is a resolution stopped by a dependency not committed yet.
+// The instances on its stack all depend on the blocker.
+type sccResolution struct {
+	frames  []sccFrame
+	stack   []*Instance
+	blocker instanceRef
+}
+
 // Assumption this function is based on:
 // - If a node is executed, all SCC it belongs to or depending has been executed.
+//
+// It's the iterative version of tarjan's algorithm, frames of the
+// recursive version are kept in r.sccFrames, so long dependency chains
+// don't grow the goroutine stack. If a dependency is not committed yet,
+// it is kept in r.sccBlocker and false is returned. The sccs completed
+// before are still executed, the frames and the stack are kept in
+// r.suspended, and the next resolution from the same node goes on from
+// there, instead of walking the graph again.
+// Another resolution meeting an instance on the stack of a suspended one
+// is blocked on the same blocker, its own stack is cleared.
 func (r *Replica) resolveConflicts(node *Instance) bool {
-	v2Log.Infof("resolve for [%v][%v]\n", node.rowId, node.id)
 	if node == nil || !node.isAtStatus(committed) {
 		panic("")
 	}
+	if r.logger.V(logger.Execute, 2) {
+		r.logger.Info(logger.Execute, 2, "resolve", node.logFields())
+	}
 
-	node.sccIndex = r.sccIndex
-	node.sccLowlink = r.sccIndex
-	r.sccIndex++
-
-	r.pushSccStack(node)
-	for iSpace := 0; iSpace < int(r.Size); iSpace++ {
-		dep := node.deps[iSpace]
-		if r.IsCheckpoint(dep) {
-			continue
+	r.sccBlocker = instanceRef{}
+	root := instanceRef{node.rowId, node.id}
+	var frames []sccFrame
+	if res, ok := r.suspended[root]; ok {
+		delete(r.suspended, root)
+		frames, r.sccStack = res.frames, res.stack
+	} else if node.sccOnStack {
+		r.sccBlocker = r.suspendedBlocker(node)
+		return false
+	} else {
+		r.sccStack = r.sccStack[:0]
+		r.visitScc(node, node)
+		frames = append(r.sccFrames[:0], sccFrame{node, 0})
+	}
+	suspended := false
+	defer func() {
+		// keep the buffer for the next resolution
+		if !suspended {
+			r.sccFrames = frames[:0]
 		}
+	}()
 
-		neighbor := r.InstanceMatrix[iSpace][dep]
-		if neighbor == nil || !neighbor.isAtStatus(committed) {
-			r.clearStack()
-			return false
-		}
+	for len(frames) > 0 {
+		top := &frames[len(frames)-1]
 
-		if neighbor.isExecuted() {
-			continue
-		}
+		if top.iSpace < int(r.Size) {
+			iSpace := top.iSpace
+			top.iSpace++
+
+			dep := top.node.deps[iSpace]
+			if r.IsCheckpoint(dep) {
+				continue
+			}
 
-		if neighbor.sccIndex == 0 {
-			if ok := r.resolveConflicts(neighbor); !ok {
-				r.clearStack()
+			neighbor := r.InstanceMatrix[iSpace][dep]
+			if neighbor == nil || !neighbor.isAtStatus(committed) {
+				r.sccBlocker = instanceRef{uint8(iSpace), dep}
+				// the dep is looked at again when resumed
+				top.iSpace--
+				r.suspended[root] = &sccResolution{
+					frames:  frames,
+					stack:   r.sccStack,
+					blocker: r.sccBlocker,
+				}
+				r.sccFrames = nil
+				r.sccStack = make([]*Instance, 0)
+				suspended = true
 				return false
 			}
-			if neighbor.sccLowlink < node.sccLowlink {
-				node.sccLowlink = neighbor.sccLowlink
+
+			if neighbor.isExecuted() {
+				continue
+			}
+
+			if neighbor.sccIndex == 0 {
+				r.visitScc(neighbor, node)
+				frames = append(frames, sccFrame{neighbor, 0})
+			} else if neighbor.sccOnStack {
+				if neighbor.sccRoot != node {
+					r.sccBlocker = r.suspendedBlocker(neighbor)
+					r.clearStack()
+					return false
+				}
+				if neighbor.sccLowlink < top.node.sccLowlink {
+					top.node.sccLowlink = neighbor.sccLowlink
+				}
 			}
-		} else if r.inSccStack(neighbor) {
-			if neighbor.sccLowlink < node.sccLowlink {
-				node.sccLowlink = neighbor.sccLowlink
+			continue
+		}
+
+		// all deps of the node are visited
+		current := top.node
+		frames = frames[:len(frames)-1]
+
+		// found one SCC
+		if current.sccLowlink == current.sccIndex {
+			singleScc := make(sccNodesQueue, 0)
+			for {
+				n := r.popSccStack()
+				singleScc = append(singleScc, n)
+				if current == n {
+					break
+				}
 			}
+			sort.Sort(singleScc)
+			r.sccResults = append(r.sccResults, singleScc)
 		}
-	}
 
-	// found one SCC
-	if node.sccLowlink == node.sccIndex {
-		singleScc := make(sccNodesQueue, 0)
-		for {
-			n := r.popSccStack()
-			singleScc = append(singleScc, n)
-			if node == n {
-				break
+		if len(frames) > 0 {
+			parent := frames[len(frames)-1].node
+			if current.sccLowlink < parent.sccLowlink {
+				parent.sccLowlink = current.sccLowlink
 			}
 		}
-		sort.Sort(singleScc)
-		r.sccResults = append(r.sccResults, singleScc)
 	}
 	return true
 }
 
+func (r *Replica) visitScc(node *Instance, root *Instance) {
+	node.sccIndex = r.sccIndex
+	node.sccLowlink = r.sccIndex
+	node.sccRoot = root
+	r.sccIndex++
+	r.pushSccStack(node)
+}
+
+// suspendedBlocker returns the blocker of the suspended
+// resolution the instance is on the stack of.
+func (r *Replica) suspendedBlocker(i *Instance) instanceRef {
+	return r.suspended[instanceRef{i.sccRoot.rowId, i.sccRoot.id}].blocker
+}
+
 func (r *Replica) pushSccStack(i *Instance) {
-	v2Log.Infof("push stack [%v][%v]\n", i.rowId, i.id)
-	r.sccStack.PushBack(i)
+	if r.logger.V(logger.Execute, 2) {
+		r.logger.Info(logger.Execute, 2, "push stack", i.logFields())
+	}
+	i.sccOnStack = true
+	r.sccStack = append(r.sccStack, i)
 }
 
-// TODO: this could be optimized in O(1) with marking flag.
 func (r *Replica) inSccStack(other *Instance) bool {
-	iter := r.sccStack.Front()
-	for iter != nil {
-		self := iter.Value.(*Instance)
-		if self == other {
-			return true
-		}
-		iter = iter.Next()
-	}
-	return false
+	return other.sccOnStack
 }
 
 func (r *Replica) popSccStack() *Instance {
-	res := r.sccStack.Back().Value.(*Instance)
-	r.sccStack.Remove(r.sccStack.Back())
-	v2Log.Infof("pop stack [%v][%v]\n", res.rowId, res.id)
+	res := r.sccStack[len(r.sccStack)-1]
+	r.sccStack = r.sccStack[:len(r.sccStack)-1]
+	res.sccOnStack = false
+	if r.logger.V(logger.Execute, 2) {
+		r.logger.Info(logger.Execute, 2, "pop stack", res.logFields())
+	}
 	return res
 }
 
 func (r *Replica) clearStack() {
-	iter := r.sccStack.Front()
-	for iter != nil {
-		instance := iter.Value.(*Instance)
-		v2Log.Infof("clear [%v][%v]\n", instance.rowId, instance.id)
+	for _, instance := range r.sccStack {
+		if r.logger.V(logger.Execute, 2) {
+			r.logger.Info(logger.Execute, 2, "clear", instance.logFields())
+		}
 		instance.sccIndex = 0
 		instance.sccLowlink = 0
-		iter = iter.Next()
+		instance.sccOnStack = false
+		instance.sccRoot = nil
 	}
-	r.sccStack.Init()
+	r.sccStack = r.sccStack[:0]
 }
 
 // interfaces for sorting sccResult
@@ -848,19 +1816,22 @@ func (s sccNodesQueue) Less(i, j int) bool {
 	return false
 }
 
-func printDependencies(msg message.Message) {
+// messageDependencies returns the dependencies carried by the message,
+// or nil if it has none.
+func messageDependencies(msg message.Message) message.Dependencies {
 	switch m := msg.(type) {
 	case *message.PreAccept:
-		v2Log.Infof("dependencies: %v\n", m.Deps)
+		return m.Deps
 	case *message.PreAcceptReply:
-		v2Log.Infof("dependencies: %v\n", m.Deps)
+		return m.Deps
 	case *message.Accept:
-		v2Log.Infof("dependencies: %v\n", m.Deps)
+		return m.Deps
 	case *message.PrepareReply:
-		v2Log.Infof("dependencies: %v\n", m.Deps)
+		return m.Deps
 	case *message.Commit:
-		v2Log.Infof("dependencies: %v\n", m.Deps)
+		return m.Deps
 	}
+	return nil
 }
 
 // store and restore the instance
@@ -868,31 +1839,25 @@ func (r *Replica) StoreSingleInstance(inst *Instance) error {
 	var buffer bytes.Buffer
 
 	p := inst.Pack()
-	key := fmt.Sprintf("%v-%v-%v", r.Id, p.RowId, p.Id)
+	key := instanceKey(r.Id, p.RowId, p.Id)
 	enc := gob.NewEncoder(&buffer)
 	err := enc.Encode(p)
 	if err != nil {
 		return err
 	}
-	return r.store.Put(key, buffer.Bytes())
+	if r.logger.V(logger.Persist, 2) {
+		r.logger.Info(logger.Persist, 2, "store instance", inst.logFields())
+	}
+	return r.logStoreError(r.store.Put(key, buffer.Bytes()))
 }
 
 func (r *Replica) RestoreSingleInstance(rowId uint8, instanceId uint64) (*Instance, error) {
-	inst := NewInstance(r, rowId, instanceId)
-	var p PackedInstance
-
-	key := fmt.Sprintf("%v-%v-%v", r.Id, inst.rowId, inst.id)
-	b, err := r.store.Get(key)
-	if err != nil {
-		return nil, err
-	}
-	buffer := bytes.NewBuffer(b)
-	dec := gob.NewDecoder(buffer)
-	err = dec.Decode(&p)
+	p, err := LoadInstance(r.store, r.Id, rowId, instanceId)
 	if err != nil {
 		return nil, err
 	}
-	inst.Unpack(&p)
+	inst := NewInstance(r, rowId, instanceId)
+	inst.Unpack(p)
 	return inst, nil
 }
 
@@ -901,7 +1866,7 @@ func (r *Replica) StoreInstances(insts ...*Instance) error {
 	for i := range insts {
 		var buffer bytes.Buffer
 		p := insts[i].Pack()
-		key := fmt.Sprintf("%v-%v-%v", r.Id, insts[i].rowId, insts[i].id)
+		key := instanceKey(r.Id, insts[i].rowId, insts[i].id)
 		enc := gob.NewEncoder(&buffer)
 		err := enc.Encode(p)
 		if err != nil {
@@ -912,10 +1877,20 @@ func (r *Replica) StoreInstances(insts ...*Instance) error {
 			Value: buffer.Bytes(),
 		}
 	}
-	return r.store.BatchPut(kvs)
+	r.logger.Info(logger.Persist, 2, "store instances", logger.Fields{"count": len(insts)})
+	return r.logStoreError(r.store.BatchPut(kvs))
+}
+
+// logStoreError logs the error of writing to the store, if any,
+// and returns it.
+func (r *Replica) logStoreError(err error) error {
+	if err != nil {
+		r.logger.Error("failed to write the store", logger.Fields{"err": err})
+	}
+	return err
 }
 
-// pack and unpack the replica
+// pack and unpack the replica, called holding the lock once it runs
 func (r *Replica) Pack() *PackedReplica {
 	p := &PackedReplica{
 		Id:             r.Id,
@@ -946,36 +1921,71 @@ func (r *Replica) StoreReplica() error {
 	var buffer bytes.Buffer
 
 	p := r.Pack()
-	key := fmt.Sprintf("%v-replica", r.Id)
+	key := replicaKey(r.Id)
 	enc := gob.NewEncoder(&buffer)
 	err := enc.Encode(p)
 	if err != nil {
 		return err
 	}
-	return r.store.Put(key, buffer.Bytes())
+	r.logger.Info(logger.Persist, 2, "store replica", nil)
+	return r.logStoreError(r.store.Put(key, buffer.Bytes()))
 }
 
+// A store where nothing was stored yet restores an empty replica.
 func (r *Replica) RestoreReplica() error {
-	var p PackedReplica
-
-	key := fmt.Sprintf("%v-replica", r.Id)
-	b, err := r.store.Get(key)
+	p, err := LoadReplica(r.store, r.Id)
+	if err == epaxos.ErrorNotFound {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	buffer := bytes.NewBuffer(b)
-	dec := gob.NewDecoder(buffer)
-	err = dec.Decode(&p)
-	r.Unpack(&p)
-
+	r.Unpack(p)
 	return nil
 }
 
+// keys of the replica and its instances in the store
+func replicaKey(id uint8) string {
+	return fmt.Sprintf("%v-replica", id)
+}
+
+func instanceKey(id uint8, rowId uint8, instanceId uint64) string {
+	return fmt.Sprintf("%v-%v-%v", id, rowId, instanceId)
+}
+
+// LoadReplica reads the replica stored by the replica id, without
+// creating a replica, e.g. to inspect the store of a stopped replica.
+func LoadReplica(store epaxos.Persistent, id uint8) (*PackedReplica, error) {
+	b, err := store.Get(replicaKey(id))
+	if err != nil {
+		return nil, err
+	}
+	p := new(PackedReplica)
+	if err := gob.NewDecoder(bytes.NewBuffer(b)).Decode(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadInstance reads an instance stored by the replica id. It returns
+// epaxos.ErrorNotFound if it's not stored, e.g. it's a checkpoint.
+func LoadInstance(store epaxos.Persistent, id uint8, rowId uint8, instanceId uint64) (*PackedInstance, error) {
+	b, err := store.Get(instanceKey(id, rowId, instanceId))
+	if err != nil {
+		return nil, err
+	}
+	p := new(PackedInstance)
+	if err := gob.NewDecoder(bytes.NewBuffer(b)).Decode(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
 // recover from persistent storage
 func (r *Replica) RecoverFromPersistent() error {
 	err := r.RestoreReplica()
 	if err != nil {
-		glog.Errorln("replica.New: failed to restore replica info")
+		r.logger.Error("failed to restore replica info", logger.Fields{"err": err})
 		return err
 	}
 
@@ -986,10 +1996,29 @@ func (r *Replica) RecoverFromPersistent() error {
 			}
 			inst, err := r.RestoreSingleInstance(i, j)
 			if err != nil && err != epaxos.ErrorNotFound {
-				glog.Errorf("replica.New: failed to restore instance info, for [%v][%v]\n", i, j)
+				r.logger.Error("failed to restore instance info", logger.Fields{
+					"row":      i,
+					"instance": j,
+					"err":      err,
+				})
 				return err
 			}
 			r.InstanceMatrix[i][j] = inst
+			if inst != nil {
+				r.indexInstance(inst)
+			}
+		}
+	}
+	r.reconcileExecution()
+	for i := range r.hashedUpTo {
+		r.hashedUpTo[i] = r.ExecutedUpTo[i]
+		r.digestSentUpTo[i] = r.ExecutedUpTo[i]
+		r.rowHashValid[i] = r.IsCheckpoint(r.ExecutedUpTo[i])
+	}
+	if r.feed != nil && r.feed.store != nil {
+		if err := r.feed.restore(); err != nil {
+			r.logger.Error("failed to restore execution feed", logger.Fields{"err": err})
+			return err
 		}
 	}
 	return nil
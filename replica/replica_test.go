@@ -1,12 +1,18 @@
 package replica
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/logger"
 	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/persistent"
+	"github.com/go-distributed/epaxos/statemachine"
 	"github.com/go-distributed/epaxos/test"
 	"github.com/go-distributed/epaxos/transporter"
 	"github.com/stretchr/testify/assert"
@@ -557,7 +563,7 @@ func TestNoTimeout1(t *testing.T) {
 	time.Sleep(2 * r.TimeoutInterval)
 	go r.checkTimeout()
 	select {
-	case <-r.MessageChan:
+	case <-r.timeouts:
 		t.Fatal("shouldn't get a timeout message")
 	default:
 	}
@@ -571,7 +577,7 @@ func TestNoTimeout2(t *testing.T) {
 	time.Sleep(2 * r.TimeoutInterval)
 	go r.checkTimeout()
 	select {
-	case <-r.MessageChan:
+	case <-r.timeouts:
 		t.Fatal("shouldn't get a timeout message for committed instance")
 	default:
 	}
@@ -587,14 +593,14 @@ func TestTimeout1(t *testing.T) {
 	time.Sleep(r.TimeoutInterval) // wait for message sending
 
 	select {
-	case <-r.MessageChan:
+	case <-r.timeouts:
 	default:
 		t.Fatal("should get a timeout message from a uncommitted instance")
 	}
 
 	time.Sleep(r.TimeoutInterval) // wait for message sending
 	select {
-	case <-r.MessageChan:
+	case <-r.timeouts:
 		t.Fatal("should get only one timeout message from a uncommitted instance")
 	default:
 	}
@@ -622,7 +628,7 @@ func TestTimeout2(t *testing.T) {
 				continue
 			}
 			select {
-			case msg := <-r.MessageChan:
+			case msg := <-r.timeouts:
 				assert.Equal(t, msg, &message.Timeout{
 					ReplicaId:  uint8(i),
 					InstanceId: uint64(j),
@@ -634,12 +640,76 @@ func TestTimeout2(t *testing.T) {
 	}
 	time.Sleep(r.TimeoutInterval) // wait for message sending
 	select {
-	case <-r.MessageChan:
+	case <-r.timeouts:
 		t.Fatal("shouldn't get more timeout messages")
 	default:
 	}
 }
 
+// checkTimeout shouldn't block when the timeouts queue is full
+func TestTimeoutDropped(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	r.InstanceMatrix[0][1] = commonTestlibExampleAcceptedInstance()
+	r.MaxInstanceNum[0] = 1
+	for i := 0; i < cap(r.timeouts); i++ {
+		r.timeouts <- r.makeTimeout(1, 1)
+	}
+	time.Sleep(2 * r.TimeoutInterval)
+
+	done := make(chan struct{})
+	go func() {
+		r.checkTimeout()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("checkTimeout is blocked by a full queue")
+	}
+	assert.Equal(t, len(r.timeouts), cap(r.timeouts))
+}
+
+// Messages from peers should be sorted into replies and requests.
+func TestReceiveLoop(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	go r.receiveLoop()
+	defer close(r.stop)
+
+	r.MessageChan <- &message.Commit{ReplicaId: 1, InstanceId: 1, From: 1}
+	r.MessageChan <- &message.AcceptReply{ReplicaId: 0, InstanceId: 1, From: 2}
+	assert.Equal(t, <-r.requests, &message.Commit{ReplicaId: 1, InstanceId: 1, From: 1})
+	assert.Equal(t, <-r.replies, &message.AcceptReply{ReplicaId: 0, InstanceId: 1, From: 2})
+}
+
+// Requests from peers should be handled before timeouts.
+func TestEventLoopPriority(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	r.MaxInstanceNum[1] = 5
+
+	// the timeout would start a prepare round if handled first
+	r.timeouts <- r.makeTimeout(1, 5)
+	r.requests <- &message.Commit{
+		ReplicaId:  1,
+		InstanceId: 5,
+		Cmds:       commonTestlibExampleCommands(),
+		Deps:       message.Dependencies{0, 0, 0, 0, 0},
+		From:       1,
+	}
+	go r.eventLoop()
+	defer close(r.stop)
+
+	for len(r.timeouts) > 0 || len(r.requests) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	i := r.InstanceMatrix[1][5]
+	assert.True(t, i.isAtStatus(committed))
+	assert.True(t, i.isNewBorn())
+}
+
 // test the correctness of the propose id without batching
 func TestProposeIdNoBatch(t *testing.T) {
 	N := 5000
@@ -887,3 +957,614 @@ func TestStoreAndRestoreReplica(t *testing.T) {
 	r.store.Drop()
 	rr.store.Drop()
 }
+
+// A built-in state machine is empty after a restart, so the restored
+// instances should be executed again.
+func TestRestoreReplaysStateMachine(t *testing.T) {
+	param := &Param{
+		ReplicaId:        0,
+		Size:             5,
+		StateMachine:     statemachine.NewKV(),
+		Transporter:      transporter.NewDummyTR(0, 5),
+		EnablePersistent: true,
+		PersistentPath:   "/tmp/test-replay",
+	}
+	r, err := New(param)
+	assert.NoError(t, err)
+
+	i := NewInstance(r, 1, 1)
+	i.cmds = message.Commands{message.Command("put a 1")}
+	i.deps = r.makeInitialDeps()
+	i.status = committed
+	r.InstanceMatrix[1][1] = i
+	r.MaxInstanceNum[1] = 1
+	r.findAndExecute()
+	assert.True(t, i.isExecuted())
+	assert.NoError(t, r.StoreReplica())
+	r.store.Close()
+
+	kv := statemachine.NewKV()
+	param.StateMachine = kv
+	param.Transporter = transporter.NewDummyTR(0, 5)
+	param.Restore = true
+	rr, err := New(param)
+	assert.NoError(t, err)
+	defer rr.store.Drop()
+
+	assert.Equal(t, rr.ExecutedUpTo[1], uint64(0))
+	assert.False(t, rr.InstanceMatrix[1][1].isExecuted())
+	rr.findAndExecute()
+	v, ok := kv.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, v, "1")
+	assert.Equal(t, rr.ExecutedUpTo[1], uint64(1))
+}
+
+// A store where nothing was stored yet should restore an empty replica.
+func TestRestoreEmptyStore(t *testing.T) {
+	param := &Param{
+		ReplicaId:        0,
+		Size:             5,
+		StateMachine:     new(test.DummySM),
+		Transporter:      transporter.NewDummyTR(0, 5),
+		EnablePersistent: true,
+		PersistentPath:   "/tmp/test-empty",
+	}
+	r, err := New(param)
+	assert.NoError(t, err)
+	r.store.Close()
+
+	param.Restore = true
+	param.Transporter = transporter.NewDummyTR(0, 5)
+	rr, err := New(param)
+	assert.NoError(t, err)
+	defer rr.store.Drop()
+	assert.Equal(t, rr.ProposeNum, r.ProposeNum)
+}
+
+// This func tests that executeList() passes the applied instances
+// to a checkpointed state machine.
+func TestExecuteListWithCheckpointedSM(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	sm := test.NewDummyCheckpointSM()
+	r.StateMachine = sm
+
+	makeCommitedInstances(r)
+	assert.True(t, r.resolveConflicts(r.InstanceMatrix[0][6]))
+	assert.Nil(t, r.executeList())
+
+	for i := range r.InstanceMatrix {
+		assert.True(t, sm.Applied.Contains(uint8(i), uint64(i+2)))
+		assert.True(t, sm.Applied.Contains(uint8(i), uint64(i+4)))
+		assert.False(t, sm.Applied.Contains(uint8(i), uint64(i+1)))
+	}
+	assert.True(t, sm.Applied.Contains(0, 6))
+	assert.False(t, sm.Applied.Contains(0, 7))
+}
+
+// After a restart, executed flags and ExecutedUpTo should follow
+// the applied index of a checkpointed state machine.
+func TestReconcileExecution(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	sm := test.NewDummyCheckpointSM()
+	sm.Applied = &epaxos.AppliedIndex{
+		UpTo:  []uint64{2, 0, 0, 0, 0},
+		Extra: [][]uint64{{4}, nil, nil, nil, nil},
+	}
+	r.StateMachine = sm
+
+	for j := uint64(1); j <= 4; j++ {
+		r.InstanceMatrix[0][j] = NewInstance(r, 0, j)
+		r.InstanceMatrix[0][j].status = committed
+	}
+	r.MaxInstanceNum[0] = 4
+	// persisted flags are stale: [0][2] was applied but not marked,
+	// [0][3] was marked but the state machine lost it.
+	r.InstanceMatrix[0][1].executed = true
+	r.InstanceMatrix[0][3].executed = true
+	r.ExecutedUpTo[0] = 3
+
+	r.reconcileExecution()
+
+	assert.True(t, r.InstanceMatrix[0][1].isExecuted())
+	assert.True(t, r.InstanceMatrix[0][2].isExecuted())
+	assert.False(t, r.InstanceMatrix[0][3].isExecuted())
+	assert.True(t, r.InstanceMatrix[0][4].isExecuted())
+	assert.Equal(t, r.ExecutedUpTo[0], uint64(2))
+}
+
+// An instance failed deterministically should be applied, and not
+// executed again after a restart which lost its executed flag.
+func TestCommandErrorAppliedAfterRestart(t *testing.T) {
+	sm := test.NewDummyCheckpointSM()
+	commit := func(r *Replica) *Instance {
+		i := NewInstance(r, 0, 1)
+		i.cmds = message.Commands{message.Command("a"), message.Command("invalid")}
+		i.deps = r.makeInitialDeps()
+		i.status = committed
+		r.InstanceMatrix[0][1] = i
+		r.MaxInstanceNum[0] = 1
+		return i
+	}
+
+	r := commonTestlibExampleReplica()
+	r.StateMachine = sm
+	i := commit(r)
+	r.findAndExecute()
+	assert.True(t, i.isExecuted())
+	assert.Equal(t, i.Results(), []interface{}{"a", test.ErrInvalidCommand})
+	assert.True(t, sm.Applied.Contains(0, 1))
+
+	// restarted before the executed flag was stored
+	rr := commonTestlibExampleReplica()
+	rr.StateMachine = sm
+	i = commit(rr)
+	rr.reconcileExecution()
+	rr.findAndExecute()
+	assert.True(t, i.isExecuted())
+	assert.Equal(t, rr.ExecutedUpTo[0], uint64(1))
+	assert.Equal(t, sm.ExecutionLog, []string{"a"})
+}
+
+// This func tests that executeList() on a concurrent state machine
+// executes an scc only after all sccs it depends on.
+func TestExecuteListConcurrently(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	sm := test.NewDummyConcurrentSM()
+	r.StateMachine = sm
+	r.concurrentExecution = true
+	r.executeWorkers = 3
+
+	// [1][1] -> [0][1], [3][1] -> [1][1] and [2][1], [4][1] alone
+	for row := uint8(0); row < r.Size; row++ {
+		inst := NewInstance(r, row, 1)
+		inst.cmds = message.Commands{message.Command(fmt.Sprintf("%d", row))}
+		inst.deps = r.makeInitialDeps()
+		inst.status = committed
+		r.InstanceMatrix[row][1] = inst
+	}
+	r.InstanceMatrix[1][1].deps[0] = 1
+	r.InstanceMatrix[3][1].deps[1] = 1
+	r.InstanceMatrix[3][1].deps[2] = 1
+
+	r.sccResults = make([][]*Instance, 0)
+	for _, row := range []uint8{0, 2, 4, 1, 3} {
+		r.sccResults = append(r.sccResults, []*Instance{r.InstanceMatrix[row][1]})
+	}
+	assert.Nil(t, r.executeList())
+
+	pos := make(map[string]int)
+	for n, c := range sm.ExecutionLog {
+		pos[c] = n
+	}
+	assert.Equal(t, len(pos), 5)
+	assert.True(t, pos["0"] < pos["1"])
+	assert.True(t, pos["1"] < pos["3"])
+	assert.True(t, pos["2"] < pos["3"])
+	for row := uint8(0); row < r.Size; row++ {
+		assert.True(t, r.InstanceMatrix[row][1].isExecuted())
+	}
+}
+
+// After an error, executeList() should not start the dependent sccs.
+func TestExecuteListConcurrentlyError(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	sm := test.NewDummyConcurrentSM()
+	r.StateMachine = sm
+	r.concurrentExecution = true
+	r.executeWorkers = 2
+
+	for row := uint8(0); row < 2; row++ {
+		inst := NewInstance(r, row, 1)
+		inst.deps = r.makeInitialDeps()
+		inst.status = committed
+		r.InstanceMatrix[row][1] = inst
+	}
+	r.InstanceMatrix[0][1].cmds = message.Commands{message.Command("error")}
+	r.InstanceMatrix[1][1].cmds = message.Commands{message.Command("1")}
+	r.InstanceMatrix[1][1].deps[0] = 1
+
+	r.sccResults = [][]*Instance{
+		{r.InstanceMatrix[0][1]},
+		{r.InstanceMatrix[1][1]},
+	}
+	assert.Equal(t, r.executeList(), epaxos.ErrStateMachineExecution)
+	assert.Equal(t, len(sm.ExecutionLog), 0)
+	assert.False(t, r.InstanceMatrix[1][1].isExecuted())
+}
+
+// makeChainReplica creates perRow committed instances in each row of a
+// replica with 5 rows. Without cycles, the instances form one chain
+// [0][1] -> [1][1] -> ... -> [4][1] -> [0][2] -> ... -> [4][perRow].
+// With cycles, the instances of the same id form one scc, and each
+// scc depends on the next id.
+func makeChainReplica(perRow uint64, cycles bool) *Replica {
+	r, err := New(&Param{
+		ReplicaId:       0,
+		Size:            5,
+		CheckpointCycle: 1 << 20,
+		StateMachine:    test.NewDummySM(),
+		Transporter:     transporter.NewDummyTR(0, 5),
+	})
+	if err != nil {
+		panic(err)
+	}
+	makeChain(r, perRow, cycles)
+	return r
+}
+
+// makeChain creates the instances of makeChainReplica in r.
+func makeChain(r *Replica, perRow uint64, cycles bool) {
+	last := int(r.Size) - 1
+	for id := uint64(1); id <= perRow; id++ {
+		for row := 0; row <= last; row++ {
+			inst := NewInstance(r, uint8(row), id)
+			inst.cmds = message.Commands{message.Command(fmt.Sprintf("%d-%d", row, id))}
+			inst.deps = r.makeInitialDeps()
+			inst.status = committed
+			if row < last {
+				inst.deps[row+1] = id
+			} else if cycles {
+				inst.deps[0] = id
+			} else if id < perRow {
+				inst.deps[0] = id + 1
+			}
+			if cycles && id < perRow {
+				inst.deps[row] = id + 1
+			}
+			r.InstanceMatrix[row][id] = inst
+		}
+	}
+	for row := range r.MaxInstanceNum {
+		r.MaxInstanceNum[row] = perRow
+	}
+}
+
+func resetScc(r *Replica, perRow uint64) {
+	for row := range r.InstanceMatrix {
+		for id := uint64(1); id <= perRow; id++ {
+			r.InstanceMatrix[row][id].sccIndex = 0
+			r.InstanceMatrix[row][id].sccLowlink = 0
+		}
+	}
+	r.sccStack = r.sccStack[:0]
+	r.sccResults = make([][]*Instance, 0)
+	r.sccIndex = 1
+}
+
+// This func tests that resolveConflicts() handles a dependency
+// chain of 100K instances.
+func TestResolveConflictsLongChain(t *testing.T) {
+	r := makeChainReplica(20000, false)
+	resetScc(r, 20000)
+
+	assert.True(t, r.resolveConflicts(r.InstanceMatrix[0][1]))
+	assert.Equal(t, len(r.sccResults), 100000)
+	assert.Equal(t, r.sccResults[0][0], r.InstanceMatrix[4][20000])
+	assert.Equal(t, r.sccResults[99999][0], r.InstanceMatrix[0][1])
+	assert.Equal(t, len(r.sccStack), 0)
+
+	r = makeChainReplica(20000, true)
+	resetScc(r, 20000)
+
+	assert.True(t, r.resolveConflicts(r.InstanceMatrix[0][1]))
+	assert.Equal(t, len(r.sccResults), 20000)
+	for _, scc := range r.sccResults {
+		assert.Equal(t, len(scc), 5)
+	}
+	assert.Equal(t, r.sccResults[0][0], r.InstanceMatrix[0][20000])
+	assert.Equal(t, r.sccResults[19999][0], r.InstanceMatrix[0][1])
+}
+
+// This func tests that findAndExecute() doesn't resolve a row again
+// until the instance which stopped the last resolution is committed.
+func TestFindAndExecuteBlocked(t *testing.T) {
+	r := makeChainReplica(100, false)
+	r.InstanceMatrix[4][100].deps[0] = 101
+
+	r.findAndExecute()
+	assert.Equal(t, r.blockedOn[0], instanceRef{0, 101})
+	assert.False(t, r.InstanceMatrix[0][1].isExecuted())
+	assert.True(t, r.isBlocked(0))
+
+	// the blocker is received, but not committed
+	r.InstanceMatrix[0][101] = NewInstance(r, 0, 101)
+	r.InstanceMatrix[0][101].deps = r.makeInitialDeps()
+	r.InstanceMatrix[0][101].status = accepted
+	r.MaxInstanceNum[0] = 101
+	r.findAndExecute()
+	assert.True(t, r.isBlocked(0))
+	// the walked instances are kept on the stack of the suspended
+	// resolution, the other rows meet it and are blocked on the same
+	assert.Equal(t, len(r.suspended), 1)
+	assert.Equal(t, len(r.suspended[instanceRef{0, 1}].stack), 500)
+	assert.True(t, r.InstanceMatrix[0][1].sccOnStack)
+	assert.Equal(t, r.blockedOn, []instanceRef{{0, 101}, {0, 101}, {0, 101}, {0, 101}, {0, 101}})
+
+	r.InstanceMatrix[0][101].status = committed
+	r.findAndExecute()
+	assert.False(t, r.isBlocked(0))
+	for row := range r.InstanceMatrix {
+		assert.True(t, r.InstanceMatrix[row][100].isExecuted())
+	}
+	assert.True(t, r.InstanceMatrix[0][101].isExecuted())
+	assert.Equal(t, r.ExecutedUpTo, []uint64{101, 100, 100, 100, 100})
+	assert.Equal(t, len(r.suspended), 0)
+}
+
+// This func tests that a row which meets a suspended resolution is
+// executed once the resolution is resumed by a later row.
+func TestExecuteCandidateSuspended(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	add := func(row uint8, status uint8, deps message.Dependencies) *Instance {
+		i := NewInstance(r, row, 1)
+		i.cmds = message.Commands{message.Command(fmt.Sprint(row))}
+		i.deps = deps
+		i.status = status
+		r.InstanceMatrix[row][1] = i
+		r.MaxInstanceNum[row] = 1
+		return i
+	}
+	a := add(0, accepted, message.Dependencies{0, 1, 0, 0, 0})
+	b := add(1, committed, message.Dependencies{0, 0, 1, 0, 0})
+	c := add(2, accepted, message.Dependencies{0, 0, 0, 0, 0})
+
+	// suspended from row 1, on c
+	r.executeCandidate(b)
+	assert.Equal(t, r.blockedOn[1], instanceRef{2, 1})
+
+	// row 0 meets it
+	a.status = committed
+	r.executeCandidate(a)
+	assert.Equal(t, r.blockedOn[0], instanceRef{2, 1})
+	assert.False(t, a.isExecuted())
+
+	c.status = committed
+	r.executeCandidate(c)
+	assert.True(t, c.isExecuted())
+	assert.True(t, b.isExecuted())
+	assert.True(t, a.isExecuted())
+	assert.Equal(t, r.ExecutedUpTo[:3], []uint64{1, 1, 1})
+	assert.Equal(t, len(r.suspended), 0)
+}
+
+func benchmarkResolveConflicts(b *testing.B, cycles bool) {
+	r := makeChainReplica(20000, cycles)
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		resetScc(r, 20000)
+		b.StartTimer()
+		if !r.resolveConflicts(r.InstanceMatrix[0][1]) {
+			b.Fatal("resolveConflicts failed")
+		}
+	}
+}
+
+func BenchmarkResolveConflictsChain(b *testing.B) {
+	benchmarkResolveConflicts(b, false)
+}
+
+func BenchmarkResolveConflictsCycles(b *testing.B) {
+	benchmarkResolveConflicts(b, true)
+}
+
+func benchmarkFindAndExecuteBlocked(b *testing.B, reuse bool) {
+	r := makeChainReplica(20000, false)
+	r.InstanceMatrix[4][20000].deps[0] = 20001
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if !reuse {
+			r.blockedOn = make([]instanceRef, r.Size)
+			for root, res := range r.suspended {
+				r.sccStack = res.stack
+				r.clearStack()
+				delete(r.suspended, root)
+			}
+		}
+		r.findAndExecute()
+	}
+}
+
+// A tick where every row is blocked by the end of a 100K instances chain.
+func BenchmarkFindAndExecuteBlocked(b *testing.B) {
+	benchmarkFindAndExecuteBlocked(b, true)
+}
+
+func BenchmarkFindAndExecuteBlockedNoReuse(b *testing.B) {
+	benchmarkFindAndExecuteBlocked(b, false)
+}
+
+// Deterministic errors are recorded as results of the failed commands,
+// and the execution continues.
+func TestExecuteWithCommandError(t *testing.T) {
+	r := makeChainReplica(2, false)
+	r.InstanceMatrix[2][1].cmds = message.Commands{
+		message.Command("x"),
+		message.Command("invalid"),
+	}
+
+	r.findAndExecute()
+	assert.True(t, r.Healthy())
+	for row := range r.InstanceMatrix {
+		assert.True(t, r.InstanceMatrix[row][2].isExecuted())
+	}
+	assert.Equal(t, r.InstanceMatrix[2][1].Results(),
+		[]interface{}{"x", test.ErrInvalidCommand})
+	assert.Equal(t, r.InstanceMatrix[0][1].Results(), []interface{}{"0-1"})
+}
+
+// Other errors stop the execution and are reported instead of panicking.
+func TestExecuteWithFatalError(t *testing.T) {
+	r := makeChainReplica(2, false)
+	r.InstanceMatrix[2][1].cmds = message.Commands{message.Command("error")}
+	var reported error
+	r.onFatalError = func(err error) { reported = err }
+
+	done := make(chan struct{})
+	go func() {
+		r.executeLoop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("execute loop should stop")
+	}
+
+	assert.False(t, r.Healthy())
+	assert.Equal(t, r.Err(), epaxos.ErrStateMachineExecution)
+	assert.Equal(t, reported, epaxos.ErrStateMachineExecution)
+	assert.True(t, r.InstanceMatrix[3][1].isExecuted())
+	assert.False(t, r.InstanceMatrix[2][1].isExecuted())
+	assert.False(t, r.InstanceMatrix[0][1].isExecuted())
+	assert.Nil(t, r.InstanceMatrix[2][1].Results())
+}
+
+// This func tests a custom error policy.
+func TestExecuteWithErrorPolicy(t *testing.T) {
+	r := makeChainReplica(2, false)
+	r.InstanceMatrix[2][1].cmds = message.Commands{message.Command("error")}
+	r.errorPolicy = func(err error) bool {
+		return err == epaxos.ErrStateMachineExecution
+	}
+
+	r.findAndExecute()
+	assert.True(t, r.Healthy())
+	assert.True(t, r.InstanceMatrix[0][1].isExecuted())
+	assert.Equal(t, r.InstanceMatrix[2][1].Results(),
+		[]interface{}{epaxos.ErrStateMachineExecution})
+}
+
+// No-ops should be proposed in their own instances.
+func TestProposeNoop(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	go func() {
+		for n := 0; n < 2; n++ {
+			r.handleProposal(<-r.proposals)
+		}
+	}()
+
+	noop := newProposeRequest()
+	noop.noop = true
+	a := newProposeRequest(message.Command("a"))
+	requests := []*proposeRequest{
+		a,
+		noop,
+		newProposeRequest(message.Command("b")),
+	}
+	r.batchPropose(&requests)
+
+	assert.Equal(t, <-noop.id, uint64(1))
+	assert.Equal(t, <-a.id, uint64(2))
+	assert.Nil(t, r.InstanceMatrix[0][1].cmds)
+	// a no-op conflicts with everything
+	assert.Equal(t, r.InstanceMatrix[0][1].deps, message.Dependencies{0, 0, 0, 0, 0})
+
+	assert.Equal(t, r.InstanceMatrix[0][2].cmds, message.Commands{
+		message.Command("a"),
+		message.Command("b"),
+	})
+	assert.Equal(t, r.InstanceMatrix[0][2].deps[0], uint64(1))
+}
+
+// This func tests that Recover() starts a prepare round for the instance.
+func TestRecover(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	r.ProposeNum = 3
+
+	assert.Equal(t, r.Recover(5, 1), ErrInvalidInstance)
+	assert.Equal(t, r.Recover(1, 0), ErrInvalidInstance)
+	assert.Equal(t, r.Recover(1, defaultInstancesLength), ErrInvalidInstance)
+	assert.Equal(t, r.Recover(0, 3), ErrInvalidInstance)
+	assert.Equal(t, len(r.requests), 0)
+
+	assert.Nil(t, r.Recover(0, 2))
+	assert.Nil(t, r.Recover(1, 5))
+	assert.Equal(t, <-r.requests, &message.Timeout{
+		ReplicaId:  0,
+		InstanceId: 2,
+		From:       0,
+	})
+
+	r.dispatch(<-r.requests)
+	i := r.InstanceMatrix[1][5]
+	assert.True(t, i.isAtStatus(preparing))
+	assert.Equal(t, i.ballot.GetReplicaId(), r.Id)
+
+	// a committed instance is not affected
+	i.status = committed
+	assert.Nil(t, r.Recover(1, 5))
+	r.dispatch(<-r.requests)
+	assert.True(t, i.isAtStatus(committed))
+}
+
+// This func tests that dispatching logs the instance fields with the
+// injected logger, only at the verbosity of the dispatch component.
+func TestDispatchLogging(t *testing.T) {
+	buf := new(bytes.Buffer)
+	r, err := New(&Param{
+		ReplicaId:    0,
+		Size:         5,
+		StateMachine: new(test.DummySM),
+		Transporter:  transporter.NewDummyTR(0, 5),
+		Logger:       logger.NewJSON(buf, logger.Verbosity{logger.Dispatch: 1}),
+	})
+	assert.NoError(t, err)
+
+	r.dispatch(&message.Timeout{ReplicaId: 1, InstanceId: 5, From: 0})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, len(lines), 4)
+
+	entry := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal([]byte(lines[2]), &entry))
+	assert.Equal(t, entry["msg"], "status after")
+	assert.Equal(t, entry["component"], "dispatch")
+	assert.Equal(t, entry["replica"], float64(0))
+	assert.Equal(t, entry["row"], float64(1))
+	assert.Equal(t, entry["instance"], float64(5))
+	assert.Equal(t, entry["status"], "Preparing")
+
+	r.logger = logger.NewJSON(buf, nil)
+	buf.Reset()
+	r.dispatch(&message.Timeout{ReplicaId: 1, InstanceId: 6, From: 0})
+	assert.Equal(t, buf.Len(), 0)
+}
+
+// This func tests loading the store of a stopped replica, opened read-only.
+func TestLoadStored(t *testing.T) {
+	param := &Param{
+		ReplicaId:      1,
+		Size:           3,
+		StateMachine:   new(test.DummySM),
+		Transporter:    transporter.NewDummyTR(1, 3),
+		PersistentPath: "/tmp/test-load",
+	}
+	r, err := New(param)
+	assert.NoError(t, err)
+	defer r.store.Drop()
+
+	inst := NewInstance(r, 2, 7)
+	inst.cmds = message.Commands{message.Command("a")}
+	inst.status = committed
+	r.MaxInstanceNum[2] = 7
+	assert.NoError(t, r.StoreSingleInstance(inst))
+	assert.NoError(t, r.StoreReplica())
+	r.store.Close()
+
+	store, err := persistent.OpenReadOnly("/tmp/test-load")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	p, err := LoadReplica(store, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, p.MaxInstanceNum, []uint64{0, 0, 7})
+	_, err = LoadReplica(store, 0)
+	assert.Equal(t, err, epaxos.ErrorNotFound)
+
+	pi, err := LoadInstance(store, 1, 2, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, pi.Cmds, inst.cmds)
+	assert.Equal(t, pi.Status, committed)
+	_, err = LoadInstance(store, 1, 2, 6)
+	assert.Equal(t, err, epaxos.ErrorNotFound)
+}
@@ -0,0 +1,307 @@
+package replica
+
+// This file implements the lifecycle tracing of instances. If tracing is
+// enabled, instances record the time of their status transitions, and
+// once executed, the instances which took longer than TraceSlowThreshold
+// are kept in a ring buffer, exported as OpenTelemetry (OTLP json) spans.
+// @decision(10/18/26):
+// - Each phase is a span, named after the status the instance was in:
+// - preaccept, accept, prepare, and execute for committed instances
+// - waiting for their dependencies to be executed.
+// - The trace id is derived from the row and instance ids only, so the
+// - spans of the same instance on different replicas are in one trace.
+// - Span ids start with the replica id, which is also an attribute.
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// trace event names
+const (
+	eventPreAccepted = "preaccepted"
+	eventAccepted    = "accepted"
+	eventPreparing   = "preparing"
+	eventRecovered   = "recovered" // a prepare reverted to the former status
+	eventCommitted   = "committed"
+	eventExecuted    = "executed"
+)
+
+// spanNames are the names of the phases starting with each event.
+var spanNames = map[string]string{
+	eventPreAccepted: "preaccept",
+	eventAccepted:    "accept",
+	eventPreparing:   "prepare",
+	eventRecovered:   "recovered",
+	eventCommitted:   "execute",
+}
+
+// TraceEvent is a status transition of an instance.
+type TraceEvent struct {
+	Name string
+	Time time.Time
+}
+
+// Trace is the lifecycle of an executed instance.
+type Trace struct {
+	ReplicaId  uint8 // the replica recording the trace
+	RowId      uint8
+	InstanceId uint64
+	Events     []TraceEvent
+}
+
+// Span is a phase of a trace.
+type Span struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// Duration returns the time from the first to the last event.
+func (t *Trace) Duration() time.Duration {
+	if len(t.Events) == 0 {
+		return 0
+	}
+	return t.Events[len(t.Events)-1].Time.Sub(t.Events[0].Time)
+}
+
+// Spans returns the phases between consecutive events.
+func (t *Trace) Spans() []Span {
+	spans := make([]Span, 0, len(t.Events))
+	for k := 0; k+1 < len(t.Events); k++ {
+		spans = append(spans, Span{
+			Name:  spanNames[t.Events[k].Name],
+			Start: t.Events[k].Time,
+			End:   t.Events[k+1].Time,
+		})
+	}
+	return spans
+}
+
+// traceEvent records the transition of the instance, unless tracing
+// is disabled or it's still in the same status.
+func (i *Instance) traceEvent(name string) {
+	if i.replica == nil || !i.replica.enableTracing {
+		return
+	}
+	if n := len(i.events); n > 0 && i.events[n-1].Name == name {
+		return
+	}
+	i.events = append(i.events, TraceEvent{Name: name, Time: time.Now()})
+}
+
+// traces is a ring buffer of the last slow traces.
+type traces struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	buf       []*Trace
+	next      int // the index of the next trace in buf
+	count     int
+}
+
+func newTraces(threshold time.Duration, length int) *traces {
+	return &traces{
+		threshold: threshold,
+		buf:       make([]*Trace, length),
+	}
+}
+
+func (ts *traces) add(t *Trace) {
+	if t.Duration() < ts.threshold {
+		return
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.buf[ts.next] = t
+	ts.next = (ts.next + 1) % len(ts.buf)
+	if ts.count < len(ts.buf) {
+		ts.count++
+	}
+}
+
+// last returns the last n traces, the latest first.
+func (ts *traces) last(n int) []*Trace {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if n > ts.count {
+		n = ts.count
+	}
+	res := make([]*Trace, n)
+	for k := range res {
+		res[k] = ts.buf[(ts.next-1-k+len(ts.buf))%len(ts.buf)]
+	}
+	return res
+}
+
+// finishTrace is called once the instance is executed.
+func (r *Replica) finishTrace(i *Instance) {
+	if !r.enableTracing || len(i.events) == 0 {
+		return
+	}
+	i.traceEvent(eventExecuted)
+	r.traces.add(&Trace{
+		ReplicaId:  r.Id,
+		RowId:      i.rowId,
+		InstanceId: i.id,
+		Events:     i.events,
+	})
+	i.events = nil
+}
+
+// SlowInstances returns the traces of the last n executed instances
+// which took longer than TraceSlowThreshold, the latest first.
+// It returns nil if tracing is disabled.
+func (r *Replica) SlowInstances(n int) []*Trace {
+	if !r.enableTracing {
+		return nil
+	}
+	return r.traces.last(n)
+}
+
+// ******************
+// ****** otlp ******
+// ******************
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"` // int64 is a string in otlp json
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+// OTLPTraces is the OpenTelemetry json encoding of traces.
+type OTLPTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+const otlpSpanKindInternal = 1
+
+func stringAttribute(key, v string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &v}}
+}
+
+func intAttribute(key string, v uint64) otlpAttribute {
+	s := strconv.FormatUint(v, 10)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &s}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// traceId returns the 16 bytes id of the trace, in hex. It only depends
+// on the instance, so the traces of all replicas are joined in one.
+func (t *Trace) traceId() string {
+	b := make([]byte, 16)
+	b[0] = t.RowId
+	binary.BigEndian.PutUint64(b[8:], t.InstanceId)
+	return hex.EncodeToString(b)
+}
+
+// spanId returns the 8 bytes id of the k-th span of the replica in
+// the trace, the root is 0.
+func spanId(replicaId uint8, k int) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(k+1))
+	b[0] = replicaId
+	return hex.EncodeToString(b)
+}
+
+// NewOTLPTraces encodes the traces recorded by the replica as spans,
+// one root span per instance, with a child span per phase.
+func NewOTLPTraces(replicaId uint8, traces []*Trace) *OTLPTraces {
+	scope := otlpScopeSpans{Spans: make([]otlpSpan, 0)}
+	scope.Scope.Name = "github.com/go-distributed/epaxos/replica"
+
+	for _, t := range traces {
+		if len(t.Events) == 0 {
+			continue
+		}
+		id := t.traceId()
+		scope.Spans = append(scope.Spans, otlpSpan{
+			TraceId:           id,
+			SpanId:            spanId(t.ReplicaId, 0),
+			Name:              "instance",
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: unixNano(t.Events[0].Time),
+			EndTimeUnixNano:   unixNano(t.Events[len(t.Events)-1].Time),
+			Attributes: []otlpAttribute{
+				intAttribute("epaxos.replica", uint64(t.ReplicaId)),
+				intAttribute("epaxos.row", uint64(t.RowId)),
+				intAttribute("epaxos.instance", t.InstanceId),
+			},
+		})
+		for k, s := range t.Spans() {
+			scope.Spans = append(scope.Spans, otlpSpan{
+				TraceId:           id,
+				SpanId:            spanId(t.ReplicaId, k+1),
+				ParentSpanId:      spanId(t.ReplicaId, 0),
+				Name:              s.Name,
+				Kind:              otlpSpanKindInternal,
+				StartTimeUnixNano: unixNano(s.Start),
+				EndTimeUnixNano:   unixNano(s.End),
+			})
+		}
+	}
+
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	rs.Resource.Attributes = []otlpAttribute{
+		stringAttribute("service.name", "epaxos"),
+		intAttribute("epaxos.replica", uint64(replicaId)),
+	}
+	return &OTLPTraces{ResourceSpans: []otlpResourceSpans{rs}}
+}
+
+// serveSlowTraces serves the last n slow instances as OTLP json,
+// n is given by the n query parameter.
+func (r *Replica) serveSlowTraces(w http.ResponseWriter, req *http.Request) {
+	n := defaultSlowTraces
+	if s := req.URL.Query().Get("n"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			http.Error(w, "invalid n", http.StatusBadRequest)
+			return
+		}
+		n = v
+	}
+	if !r.enableTracing {
+		http.Error(w, "tracing is disabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NewOTLPTraces(r.Id, r.SlowInstances(n)))
+}
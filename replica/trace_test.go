@@ -0,0 +1,138 @@
+package replica
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/test"
+	"github.com/go-distributed/epaxos/transporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func traceTestlibCluster(size int, threshold time.Duration) []*Replica {
+	nodes := make([]*Replica, size)
+	chs := make([]chan message.Message, size)
+	for i := range nodes {
+		r, err := New(&Param{
+			ReplicaId:          uint8(i),
+			Size:               uint8(size),
+			TimeoutInterval:    time.Second * 50, // disable timeout
+			StateMachine:       test.NewDummySM(),
+			Transporter:        transporter.NewDummyTR(uint8(i), size),
+			EnableTracing:      true,
+			TraceSlowThreshold: threshold,
+		})
+		if err != nil {
+			panic(err)
+		}
+		nodes[i] = r
+		chs[i] = r.MessageChan
+	}
+	for _, r := range nodes {
+		r.Transporter.(*transporter.DummyTransporter).RegisterChannels(chs)
+		r.Start()
+	}
+	return nodes
+}
+
+func traceTestlibEvents(t *Trace) []string {
+	names := make([]string, len(t.Events))
+	for k, e := range t.Events {
+		names[k] = e.Name
+	}
+	return names
+}
+
+func TestTraceSpans(t *testing.T) {
+	start := time.Unix(100, 0)
+	trace := &Trace{
+		Events: []TraceEvent{
+			{eventPreAccepted, start},
+			{eventPreparing, start.Add(time.Second)},
+			{eventAccepted, start.Add(2 * time.Second)},
+			{eventCommitted, start.Add(4 * time.Second)},
+			{eventExecuted, start.Add(7 * time.Second)},
+		},
+	}
+	assert.Equal(t, trace.Duration(), 7*time.Second)
+	assert.Equal(t, trace.Spans(), []Span{
+		{"preaccept", start, start.Add(time.Second)},
+		{"prepare", start.Add(time.Second), start.Add(2 * time.Second)},
+		{"accept", start.Add(2 * time.Second), start.Add(4 * time.Second)},
+		{"execute", start.Add(4 * time.Second), start.Add(7 * time.Second)},
+	})
+	assert.Equal(t, (&Trace{}).Duration(), time.Duration(0))
+}
+
+// The ring buffer should keep the last slow traces.
+func TestTracesLast(t *testing.T) {
+	ts := newTraces(time.Second, 3)
+	start := time.Now()
+	for id := uint64(1); id <= 5; id++ {
+		ts.add(&Trace{
+			InstanceId: id,
+			Events: []TraceEvent{
+				{eventCommitted, start},
+				{eventExecuted, start.Add(time.Duration(id%2) * time.Second)},
+			},
+		})
+	}
+	last := ts.last(5)
+	assert.Equal(t, len(last), 3)
+	assert.Equal(t, last[0].InstanceId, uint64(5))
+	assert.Equal(t, last[1].InstanceId, uint64(3))
+	assert.Equal(t, last[2].InstanceId, uint64(1))
+	assert.Equal(t, len(ts.last(1)), 1)
+}
+
+// Executed instances should be traced, and served as OTLP json.
+func TestSlowInstances(t *testing.T) {
+	nodes := traceTestlibCluster(3, time.Nanosecond)
+	defer forwardTestlibStop(nodes)
+
+	_, err := nodes[0].ProposeAndWait(message.Command("a"))
+	assert.NoError(t, err)
+
+	traces := nodes[0].SlowInstances(10)
+	assert.Equal(t, len(traces), 1)
+	assert.Equal(t, traces[0].RowId, uint8(0))
+	assert.Equal(t, traces[0].InstanceId, uint64(1))
+	assert.Equal(t, traceTestlibEvents(traces[0]),
+		[]string{eventPreAccepted, eventCommitted, eventExecuted})
+
+	w := httptest.NewRecorder()
+	nodes[0].Handler().ServeHTTP(w, httptest.NewRequest("GET", "/traces/slow?n=1", nil))
+	assert.Equal(t, w.Code, 200)
+
+	var otlp OTLPTraces
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &otlp))
+	spans := otlp.ResourceSpans[0].ScopeSpans[0].Spans
+	assert.Equal(t, len(spans), 3)
+	assert.Equal(t, spans[0].Name, "instance")
+	assert.Equal(t, spans[0].TraceId, "00000000000000000000000000000001")
+	assert.Equal(t, spans[1].Name, "preaccept")
+	assert.Equal(t, spans[1].ParentSpanId, spans[0].SpanId)
+	assert.Equal(t, spans[2].Name, "execute")
+
+	// the traces of other replicas have the same id, but other spans
+	other := NewOTLPTraces(2, []*Trace{{ReplicaId: 2, RowId: 0, InstanceId: 1, Events: traces[0].Events}})
+	otherSpans := other.ResourceSpans[0].ScopeSpans[0].Spans
+	assert.Equal(t, otherSpans[0].TraceId, spans[0].TraceId)
+	assert.NotEqual(t, otherSpans[0].SpanId, spans[0].SpanId)
+
+	w = httptest.NewRecorder()
+	nodes[0].Handler().ServeHTTP(w, httptest.NewRequest("GET", "/traces/slow?n=x", nil))
+	assert.Equal(t, w.Code, 400)
+}
+
+func TestTracingDisabled(t *testing.T) {
+	r := commonTestlibExampleReplica()
+	i := NewInstance(r, 1, 1)
+	i.enterCommitted()
+	i.SetExecuted()
+	assert.Nil(t, i.events)
+	assert.Nil(t, r.SlowInstances(1))
+}
@@ -0,0 +1,88 @@
+package replica
+
+// This file implements the propose window, which bounds the number of
+// instances of this replica proposed but not committed yet. Proposals are
+// pipelined up to the window size, then proposing waits for a commit, so
+// the propose loop stops receiving requests and Propose() callers block
+// once ProposeChan is full.
+
+import (
+	"time"
+)
+
+// WindowStats describes the occupancy of the propose window.
+type WindowStats struct {
+	Size        int    // 0 if the window is not bounded
+	Outstanding int    // instances proposed and not committed yet
+	Peak        int    // the max number of outstanding instances
+	Proposed    uint64 // instances proposed since starting
+	Committed   uint64 // proposed instances committed since starting
+	Full        uint64 // times a proposal waited for a free slot
+	FullWait    time.Duration
+}
+
+// WindowStats returns the occupancy of the propose window.
+func (r *Replica) WindowStats() WindowStats {
+	r.windowLock.Lock()
+	defer r.windowLock.Unlock()
+	stats := r.windowStats
+	stats.Size = r.proposeWindow
+	stats.Outstanding = r.outstandingCount
+	return stats
+}
+
+// outstanding returns the number of proposed instances not committed yet.
+func (r *Replica) outstanding() int {
+	r.windowLock.Lock()
+	defer r.windowLock.Unlock()
+	return r.outstandingCount
+}
+
+// acquireSlot is called before proposing an instance, it waits until
+// there is a free slot in the window. It returns false if the replica
+// is stopped meanwhile.
+func (r *Replica) acquireSlot() bool {
+	r.windowLock.Lock()
+	defer r.windowLock.Unlock()
+
+	if r.proposeWindow > 0 && r.outstandingCount >= r.proposeWindow {
+		r.windowStats.Full++
+		start := time.Now()
+		for r.outstandingCount >= r.proposeWindow {
+			r.windowLock.Unlock()
+			select {
+			case <-r.slotFreed:
+			case <-r.stop:
+				r.windowLock.Lock()
+				return false
+			}
+			r.windowLock.Lock()
+		}
+		r.windowStats.FullWait += time.Since(start)
+	}
+
+	r.outstandingCount++
+	r.windowStats.Proposed++
+	if r.outstandingCount > r.windowStats.Peak {
+		r.windowStats.Peak = r.outstandingCount
+	}
+	return true
+}
+
+// releaseSlot is called when an instance of this replica is committed.
+func (r *Replica) releaseSlot() {
+	r.windowLock.Lock()
+	if r.outstandingCount == 0 {
+		// not proposed since starting, e.g. restored
+		r.windowLock.Unlock()
+		return
+	}
+	r.outstandingCount--
+	r.windowStats.Committed++
+	r.windowLock.Unlock()
+
+	select {
+	case r.slotFreed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,59 @@
+package replica
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/test"
+	"github.com/go-distributed/epaxos/transporter"
+	"github.com/stretchr/testify/assert"
+)
+
+// Proposals should wait for a commit when the window is full.
+func TestProposeWindow(t *testing.T) {
+	r, err := New(&Param{
+		ReplicaId:     0,
+		Size:          5,
+		StateMachine:  test.NewDummySM(),
+		Transporter:   transporter.NewDummyTR(0, 5),
+		ProposeWindow: 2,
+	})
+	assert.NoError(t, err)
+	go r.receiveLoop()
+	go r.eventLoop()
+	go r.proposeLoop()
+	defer close(r.stop)
+
+	assert.Equal(t, <-r.Propose(message.Command("a")), uint64(1))
+	assert.Equal(t, <-r.Propose(message.Command("b")), uint64(2))
+
+	third := r.Propose(message.Command("c"))
+	select {
+	case <-third:
+		t.Fatal("proposed with a full window")
+	case <-time.After(20 * time.Millisecond):
+	}
+	stats := r.WindowStats()
+	assert.Equal(t, stats.Size, 2)
+	assert.Equal(t, stats.Outstanding, 2)
+	assert.Equal(t, stats.Full, uint64(1))
+
+	// commit to free a slot
+	i := r.InstanceMatrix[0][1]
+	r.MessageChan <- &message.Commit{
+		ReplicaId:  0,
+		InstanceId: 1,
+		Cmds:       i.cmds,
+		Deps:       i.deps,
+		From:       1,
+	}
+	assert.Equal(t, <-third, uint64(3))
+
+	stats = r.WindowStats()
+	assert.Equal(t, stats.Outstanding, 2)
+	assert.Equal(t, stats.Peak, 2)
+	assert.Equal(t, stats.Proposed, uint64(3))
+	assert.Equal(t, stats.Committed, uint64(1))
+	assert.True(t, stats.FullWait > 0)
+}
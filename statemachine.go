@@ -2,6 +2,8 @@ package epaxos
 
 import (
 	"errors"
+	"io"
+	"sort"
 
 	"github.com/go-distributed/epaxos/message"
 )
@@ -10,12 +12,194 @@ var (
 	ErrStateMachineExecution = errors.New("Statemachin execution error")
 )
 
+// CommandError is returned by a state machine when some commands of a batch
+// failed deterministically, e.g. a malformed command. Every replica gets the
+// same errors executing the same commands, so they are recorded as results
+// of the failed commands, and the execution continues.
+type CommandError struct {
+	// Results of all commands in the batch, the ones of failed
+	// commands are errors.
+	Results []interface{}
+}
+
+func (e *CommandError) Error() string {
+	for _, res := range e.Results {
+		if err, ok := res.(error); ok {
+			return "Command execution error: " + err.Error()
+		}
+	}
+	return "Command execution error"
+}
+
 type StateMachine interface {
 	// Execute a batch of commands
 	// Return the results in the interface array.
-	// If the state machine failed during execution, an error will return and epaxos will stop accordingly.
-	// The error should be one of the errors above
+	// If some commands failed deterministically, a *CommandError should be
+	// returned, otherwise if the state machine failed during execution,
+	// an error will return and epaxos will stop accordingly.
 	Execute(c []message.Command) ([]interface{}, error)
 	// Test if there exists any conflicts in two group of commands
 	HaveConflicts(c1 []message.Command, c2 []message.Command) bool
 }
+
+// AccessMode tells how a command accesses a conflict key.
+type AccessMode uint8
+
+const (
+	ReadAccess AccessMode = iota + 1
+	WriteAccess
+)
+
+// ConflictKey is a key touched by a command.
+type ConflictKey struct {
+	Key  string
+	Mode AccessMode
+}
+
+// ConflictsWith reports whether two accesses conflict, that is they touch
+// the same key and at least one of them writes it. Reads never conflict
+// with each other.
+func (k ConflictKey) ConflictsWith(other ConflictKey) bool {
+	return k.Key == other.Key && (k.Mode == WriteAccess || other.Mode == WriteAccess)
+}
+
+// KeyedStateMachine is a state machine that can tell the keys touched by
+// each command. Two commands conflict if any of their keys conflict, so the
+// replica can find conflicts in a key index instead of scanning instances.
+// HaveConflicts must agree with the keys.
+type KeyedStateMachine interface {
+	StateMachine
+	// Return the keys touched by the command.
+	ConflictKeys(c message.Command) []ConflictKey
+}
+
+// OwnedStateMachine is a keyed state machine whose keys are owned by
+// replicas, e.g. each replica owns a key range. Commands on the keys of a
+// replica are expected to be proposed by it, so as long as no other replica
+// touches them, the owner doesn't look for conflicts in other instance spaces.
+type OwnedStateMachine interface {
+	KeyedStateMachine
+	// Return the id of the replica owning the key.
+	Owner(key string) uint8
+}
+
+// CheckpointedStateMachine is a state machine that records which instances
+// it has applied, atomically with the effects of the commands.
+// After a restart, the replica trusts AppliedIndex() over its own executed
+// flags, so a crash between executing and persisting never re-executes or
+// skips commands.
+type CheckpointedStateMachine interface {
+	StateMachine
+	// Execute a batch of commands like Execute, and merge applied into the
+	// recorded index (see AppliedIndex.Merge) in the same atomic step.
+	// A batch failed deterministically, i.e. with a *CommandError, is
+	// applied as well: its index must be merged, or it would be executed
+	// again after a restart. It's not merged on other errors.
+	ExecuteApplied(c []message.Command, applied *AppliedIndex) ([]interface{}, error)
+	// Return the recorded index, or nil if nothing has been applied.
+	AppliedIndex() *AppliedIndex
+}
+
+// ConcurrentStateMachine is a state machine that can execute batches of
+// commands from several goroutines at the same time. The replica only does
+// so for batches that don't conflict with each other; conflicting batches
+// are still executed one by one in the same order on every replica.
+type ConcurrentStateMachine interface {
+	StateMachine
+	// ConcurrentExecution is a marker, it is never called.
+	ConcurrentExecution()
+}
+
+// SnapshotStateMachine is a state machine that can write a copy of its
+// state, e.g. for backups. The snapshot is consistent, but it's not tied
+// to the instances executed by the replica.
+type SnapshotStateMachine interface {
+	StateMachine
+	// Write the state to w, while no command is executed.
+	Snapshot(w io.Writer) error
+}
+
+// AppliedIndex describes the applied instances of each instance space:
+// every instance up to UpTo[row], plus the ones in Extra[row].
+// Extra is needed because an instance space may be executed out of order,
+// e.g. a recovered no-op or the instance right after a checkpoint.
+type AppliedIndex struct {
+	UpTo  []uint64
+	Extra [][]uint64
+}
+
+func NewAppliedIndex(size int) *AppliedIndex {
+	return &AppliedIndex{
+		UpTo:  make([]uint64, size),
+		Extra: make([][]uint64, size),
+	}
+}
+
+// Contains reports whether instance [row][id] is applied.
+func (a *AppliedIndex) Contains(row uint8, id uint64) bool {
+	if a == nil || int(row) >= len(a.UpTo) {
+		return false
+	}
+	if id <= a.UpTo[row] {
+		return true
+	}
+	for _, e := range a.Extra[row] {
+		if e == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge folds other into the receiver. UpTo takes the maximum of each row,
+// Extra takes the union, and the ids that became contiguous are compacted
+// into UpTo. Merging is commutative, so batches can be merged in any order.
+func (a *AppliedIndex) Merge(other *AppliedIndex) {
+	for len(a.UpTo) < len(other.UpTo) {
+		a.UpTo = append(a.UpTo, 0)
+		a.Extra = append(a.Extra, nil)
+	}
+
+	for row := range other.UpTo {
+		if a.UpTo[row] < other.UpTo[row] {
+			a.UpTo[row] = other.UpTo[row]
+		}
+
+		ids := append(append([]uint64{}, a.Extra[row]...), other.Extra[row]...)
+		sort.Sort(uint64Slice(ids))
+
+		extra := make([]uint64, 0, len(ids))
+		for _, id := range ids {
+			if id <= a.UpTo[row] {
+				continue
+			}
+			if id == a.UpTo[row]+1 && len(extra) == 0 {
+				a.UpTo[row] = id
+				continue
+			}
+			if len(extra) > 0 && extra[len(extra)-1] == id {
+				continue
+			}
+			extra = append(extra, id)
+		}
+		a.Extra[row] = extra
+	}
+}
+
+// Clone returns a deep copy of the index.
+func (a *AppliedIndex) Clone() *AppliedIndex {
+	c := NewAppliedIndex(len(a.UpTo))
+	copy(c.UpTo, a.UpTo)
+	for row := range a.Extra {
+		if a.Extra[row] != nil {
+			c.Extra[row] = append([]uint64{}, a.Extra[row]...)
+		}
+	}
+	return c
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
@@ -0,0 +1,118 @@
+package statemachine
+
+// The commands of the counter state machine are:
+// - add <n>: adds the signed integer n to the counter.
+// - get: reads the counter.
+// Both return the value of the counter, as an int64.
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/message"
+)
+
+// the conflict key of all the commands
+const counterKey = "counter"
+
+func init() {
+	Register("counter", func() epaxos.StateMachine { return NewCounter() })
+}
+
+// Counter is a single integer.
+type Counter struct {
+	lock    sync.Mutex
+	value   int64
+	applied *epaxos.AppliedIndex
+}
+
+func NewCounter() *Counter {
+	return new(Counter)
+}
+
+// parseCounter returns the number added by the command, and
+// whether it's a get.
+func parseCounter(c message.Command) (n int64, get bool, err error) {
+	fields := strings.Fields(string(c))
+	switch {
+	case len(fields) == 1 && fields[0] == "get":
+		return 0, true, nil
+	case len(fields) == 2 && fields[0] == "add":
+		n, err = strconv.ParseInt(fields[1], 10, 64)
+		if err == nil {
+			return n, false, nil
+		}
+	}
+	return 0, false, fmt.Errorf("%v: %q", ErrInvalidCommand, c)
+}
+
+func (ct *Counter) Execute(c []message.Command) ([]interface{}, error) {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	return ct.execute(c)
+}
+
+// ExecuteApplied executes the commands, and merges applied into
+// the applied index.
+func (ct *Counter) ExecuteApplied(c []message.Command, applied *epaxos.AppliedIndex) ([]interface{}, error) {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	results, err := ct.execute(c)
+	mergeApplied(&ct.applied, applied)
+	return results, err
+}
+
+// AppliedIndex returns the applied index, nil until a batch is executed
+// with ExecuteApplied.
+func (ct *Counter) AppliedIndex() *epaxos.AppliedIndex {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	return cloneApplied(ct.applied)
+}
+
+func (ct *Counter) execute(c []message.Command) ([]interface{}, error) {
+	return executeEach(c, func(c message.Command) (interface{}, error) {
+		n, _, err := parseCounter(c)
+		if err != nil {
+			return nil, err
+		}
+		ct.value += n
+		return ct.value, nil
+	})
+}
+
+// ConflictKeys returns the counter key, reads don't conflict
+// with each other.
+func (ct *Counter) ConflictKeys(c message.Command) []epaxos.ConflictKey {
+	_, get, err := parseCounter(c)
+	if err != nil {
+		return nil
+	}
+	mode := epaxos.WriteAccess
+	if get {
+		mode = epaxos.ReadAccess
+	}
+	return []epaxos.ConflictKey{{Key: counterKey, Mode: mode}}
+}
+
+func (ct *Counter) HaveConflicts(c1 []message.Command, c2 []message.Command) bool {
+	return haveConflicts(ct, c1, c2)
+}
+
+// Value returns the counter, read locally.
+func (ct *Counter) Value() int64 {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	return ct.value
+}
+
+// Snapshot writes the counter as a decimal number.
+func (ct *Counter) Snapshot(w io.Writer) error {
+	_, err := fmt.Fprintln(w, ct.Value())
+	return err
+}
@@ -0,0 +1,134 @@
+package statemachine
+
+// The commands of the kv state machine are:
+// - get <key>: returns the value, or nil if the key is not set.
+// - put <key> <value>: sets the key, the value is the rest of the command.
+// - delete <key>: removes the key.
+// put and delete return nil.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/message"
+)
+
+func init() {
+	Register("kv", func() epaxos.StateMachine { return NewKV() })
+}
+
+// KV is a key-value store of strings.
+type KV struct {
+	lock    sync.RWMutex
+	data    map[string]string
+	applied *epaxos.AppliedIndex
+}
+
+func NewKV() *KV {
+	return &KV{data: make(map[string]string)}
+}
+
+// parseKV splits the command into the operation, the key and the value.
+func parseKV(c message.Command) (op, key, value string, err error) {
+	parts := strings.SplitN(strings.TrimSpace(string(c)), " ", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return "", "", "", fmt.Errorf("%v: %q", ErrInvalidCommand, c)
+	}
+	op, key = parts[0], parts[1]
+	switch op {
+	case "get", "delete":
+		if len(parts) == 3 {
+			return "", "", "", fmt.Errorf("%v: %q", ErrInvalidCommand, c)
+		}
+	case "put":
+		if len(parts) < 3 {
+			return "", "", "", fmt.Errorf("%v: %q, missing value", ErrInvalidCommand, c)
+		}
+		value = parts[2]
+	default:
+		return "", "", "", fmt.Errorf("%v: %q, unknown operation %q", ErrInvalidCommand, c, op)
+	}
+	return op, key, value, nil
+}
+
+func (kv *KV) Execute(c []message.Command) ([]interface{}, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.execute(c)
+}
+
+// ExecuteApplied executes the commands, and merges applied into
+// the applied index.
+func (kv *KV) ExecuteApplied(c []message.Command, applied *epaxos.AppliedIndex) ([]interface{}, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+
+	results, err := kv.execute(c)
+	mergeApplied(&kv.applied, applied)
+	return results, err
+}
+
+// AppliedIndex returns the applied index, nil until a batch is executed
+// with ExecuteApplied.
+func (kv *KV) AppliedIndex() *epaxos.AppliedIndex {
+	kv.lock.RLock()
+	defer kv.lock.RUnlock()
+	return cloneApplied(kv.applied)
+}
+
+func (kv *KV) execute(c []message.Command) ([]interface{}, error) {
+	return executeEach(c, func(c message.Command) (interface{}, error) {
+		op, key, value, err := parseKV(c)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "get":
+			if v, ok := kv.data[key]; ok {
+				return v, nil
+			}
+		case "put":
+			kv.data[key] = value
+		case "delete":
+			delete(kv.data, key)
+		}
+		return nil, nil
+	})
+}
+
+// ConflictKeys returns the key of the command. Invalid commands
+// have no key, they don't change the state.
+func (kv *KV) ConflictKeys(c message.Command) []epaxos.ConflictKey {
+	op, key, _, err := parseKV(c)
+	if err != nil {
+		return nil
+	}
+	mode := epaxos.WriteAccess
+	if op == "get" {
+		mode = epaxos.ReadAccess
+	}
+	return []epaxos.ConflictKey{{Key: key, Mode: mode}}
+}
+
+func (kv *KV) HaveConflicts(c1 []message.Command, c2 []message.Command) bool {
+	return haveConflicts(kv, c1, c2)
+}
+
+// Get returns the value of the key, read locally.
+func (kv *KV) Get(key string) (string, bool) {
+	kv.lock.RLock()
+	defer kv.lock.RUnlock()
+	v, ok := kv.data[key]
+	return v, ok
+}
+
+// Snapshot writes the keys and values as a json object.
+func (kv *KV) Snapshot(w io.Writer) error {
+	kv.lock.RLock()
+	defer kv.lock.RUnlock()
+	return json.NewEncoder(w).Encode(kv.data)
+}
@@ -0,0 +1,21 @@
+package statemachine
+
+import (
+	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/message"
+)
+
+func init() {
+	Register("noop", func() epaxos.StateMachine { return Noop{} })
+}
+
+// Noop ignores the commands, they never conflict.
+type Noop struct{}
+
+func (Noop) Execute(c []message.Command) ([]interface{}, error) {
+	return make([]interface{}, len(c)), nil
+}
+
+func (Noop) HaveConflicts(c1 []message.Command, c2 []message.Command) bool {
+	return false
+}
@@ -0,0 +1,111 @@
+// Package statemachine implements the built-in state machines of epaxosd,
+// and a registry to select one by name:
+// - kv: a key-value store, see kv.go.
+// - counter: a single counter, see counter.go.
+// - noop: ignores the commands, to benchmark the replication alone.
+//
+// kv and counter are checkpointed state machines, which keep their applied
+// index in memory with their state. After a restart, both are empty, so the
+// replica executes all the instances restored from its store again.
+package statemachine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/message"
+)
+
+var (
+	ErrInvalidCommand = errors.New("Invalid command")
+)
+
+// Factory returns a new, empty state machine.
+type Factory func() epaxos.StateMachine
+
+var registry = make(map[string]Factory)
+
+// Register makes a state machine available by name.
+// It panics if the name is already registered.
+func Register(name string, f Factory) {
+	if _, ok := registry[name]; ok {
+		panic("statemachine: " + name + " is already registered")
+	}
+	registry[name] = f
+}
+
+// New returns a new state machine registered as name.
+func New(name string) (epaxos.StateMachine, error) {
+	f, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown state machine %q, registered: %s",
+			name, strings.Join(Names(), ", "))
+	}
+	return f(), nil
+}
+
+// Names returns the sorted names of the registered state machines.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// haveConflicts finds conflicts from the keys of the commands.
+func haveConflicts(sm epaxos.KeyedStateMachine, c1 []message.Command, c2 []message.Command) bool {
+	for i := range c1 {
+		for j := range c2 {
+			for _, k1 := range sm.ConflictKeys(c1[i]) {
+				for _, k2 := range sm.ConflictKeys(c2[j]) {
+					if k1.ConflictsWith(k2) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// mergeApplied merges the instances of an executed batch into the applied
+// index. Batches failed deterministically are applied too.
+func mergeApplied(index **epaxos.AppliedIndex, applied *epaxos.AppliedIndex) {
+	if *index == nil {
+		*index = epaxos.NewAppliedIndex(len(applied.UpTo))
+	}
+	(*index).Merge(applied)
+}
+
+// cloneApplied returns a copy of the applied index, or nil.
+func cloneApplied(index *epaxos.AppliedIndex) *epaxos.AppliedIndex {
+	if index == nil {
+		return nil
+	}
+	return index.Clone()
+}
+
+// executeEach executes the commands one by one, and returns
+// a *epaxos.CommandError if some of them are invalid.
+func executeEach(c []message.Command, f func(c message.Command) (interface{}, error)) ([]interface{}, error) {
+	results := make([]interface{}, len(c))
+	failed := false
+	for i := range c {
+		res, err := f(c[i])
+		if err != nil {
+			results[i] = err
+			failed = true
+			continue
+		}
+		results[i] = res
+	}
+	if failed {
+		return results, &epaxos.CommandError{Results: results}
+	}
+	return results, nil
+}
@@ -0,0 +1,122 @@
+package statemachine
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func statemachineTestlibCommands(cmds ...string) []message.Command {
+	c := make([]message.Command, len(cmds))
+	for i := range cmds {
+		c[i] = message.Command(cmds[i])
+	}
+	return c
+}
+
+func TestRegistry(t *testing.T) {
+	assert.Equal(t, Names(), []string{"counter", "kv", "noop"})
+
+	sm, err := New("kv")
+	assert.NoError(t, err)
+	_, ok := sm.(*KV)
+	assert.True(t, ok)
+
+	_, err = New("sql")
+	assert.Equal(t, err.Error(), `unknown state machine "sql", registered: counter, kv, noop`)
+
+	assert.Panics(t, func() { Register("kv", nil) })
+}
+
+func TestKV(t *testing.T) {
+	kv := NewKV()
+	results, err := kv.Execute(statemachineTestlibCommands(
+		"put a 1", "put b hello world", "get a", "get b", "delete a", "get a"))
+	assert.NoError(t, err)
+	assert.Equal(t, results, []interface{}{nil, nil, "1", "hello world", nil, nil})
+
+	v, ok := kv.Get("b")
+	assert.True(t, ok)
+	assert.Equal(t, v, "hello world")
+
+	// invalid commands fail alone
+	results, err = kv.Execute(statemachineTestlibCommands("put a", "put a 2", "incr a"))
+	cerr, ok := err.(*epaxos.CommandError)
+	assert.True(t, ok)
+	assert.Equal(t, len(cerr.Results), 3)
+	assert.Error(t, results[0].(error))
+	assert.Nil(t, results[1])
+	assert.Error(t, results[2].(error))
+	v, _ = kv.Get("a")
+	assert.Equal(t, v, "2")
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, kv.Snapshot(buf))
+	assert.Equal(t, buf.String(), `{"a":"2","b":"hello world"}`+"\n")
+}
+
+func TestKVConflicts(t *testing.T) {
+	kv := NewKV()
+	get := statemachineTestlibCommands("get a")
+	put := statemachineTestlibCommands("put a 1")
+	assert.False(t, kv.HaveConflicts(get, get))
+	assert.True(t, kv.HaveConflicts(get, put))
+	assert.True(t, kv.HaveConflicts(put, statemachineTestlibCommands("get b", "delete a")))
+	assert.False(t, kv.HaveConflicts(put, statemachineTestlibCommands("put b 1")))
+	assert.Nil(t, kv.ConflictKeys(message.Command("bad")))
+}
+
+func TestCounter(t *testing.T) {
+	ct := NewCounter()
+	results, err := ct.Execute(statemachineTestlibCommands("add 5", "add -2", "get"))
+	assert.NoError(t, err)
+	assert.Equal(t, results, []interface{}{int64(5), int64(3), int64(3)})
+	assert.Equal(t, ct.Value(), int64(3))
+
+	_, err = ct.Execute(statemachineTestlibCommands("add x"))
+	assert.Error(t, err)
+	assert.Equal(t, ct.Value(), int64(3))
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, ct.Snapshot(buf))
+	assert.Equal(t, buf.String(), "3\n")
+
+	get := statemachineTestlibCommands("get")
+	assert.False(t, ct.HaveConflicts(get, get))
+	assert.True(t, ct.HaveConflicts(get, statemachineTestlibCommands("add 1")))
+}
+
+// The applied index should be nil until a batch is executed with it, and
+// include the batches failed deterministically.
+func TestApplied(t *testing.T) {
+	for _, sm := range []epaxos.CheckpointedStateMachine{NewKV(), NewCounter()} {
+		assert.Nil(t, sm.AppliedIndex())
+
+		applied := epaxos.NewAppliedIndex(3)
+		applied.UpTo[1] = 2
+		_, err := sm.ExecuteApplied(statemachineTestlibCommands("invalid"), applied)
+		assert.Error(t, err)
+		assert.True(t, sm.AppliedIndex().Contains(1, 2))
+
+		applied = epaxos.NewAppliedIndex(3)
+		applied.Extra[0] = []uint64{1}
+		sm.ExecuteApplied(statemachineTestlibCommands("get"), applied)
+		index := sm.AppliedIndex()
+		assert.True(t, index.Contains(0, 1))
+		assert.True(t, index.Contains(1, 2))
+
+		// a copy is returned
+		index.UpTo[2] = 5
+		assert.False(t, sm.AppliedIndex().Contains(2, 5))
+	}
+}
+
+func TestNoop(t *testing.T) {
+	results, err := Noop{}.Execute(statemachineTestlibCommands("a", "b"))
+	assert.NoError(t, err)
+	assert.Equal(t, results, []interface{}{nil, nil})
+	assert.False(t, Noop{}.HaveConflicts(statemachineTestlibCommands("a"), statemachineTestlibCommands("a")))
+}
@@ -0,0 +1,82 @@
+package epaxos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppliedIndexContains(t *testing.T) {
+	a := NewAppliedIndex(3)
+	a.UpTo[0] = 5
+	a.Extra[1] = []uint64{3, 7}
+
+	assert.True(t, a.Contains(0, 1))
+	assert.True(t, a.Contains(0, 5))
+	assert.False(t, a.Contains(0, 6))
+	assert.True(t, a.Contains(1, 3))
+	assert.True(t, a.Contains(1, 7))
+	assert.False(t, a.Contains(1, 4))
+	assert.False(t, a.Contains(2, 1))
+	assert.False(t, a.Contains(3, 1)) // out of range
+
+	var n *AppliedIndex
+	assert.False(t, n.Contains(0, 1))
+}
+
+// Merge should take the max of UpTo, the union of Extra,
+// and compact contiguous ids into UpTo.
+func TestAppliedIndexMerge(t *testing.T) {
+	a := NewAppliedIndex(2)
+	a.UpTo[0] = 2
+	a.Extra[0] = []uint64{5, 9}
+
+	b := NewAppliedIndex(2)
+	b.UpTo[0] = 3
+	b.Extra[0] = []uint64{4, 5, 7}
+	b.UpTo[1] = 1
+	b.Extra[1] = []uint64{1, 3}
+
+	a.Merge(b)
+	assert.Equal(t, a.UpTo, []uint64{5, 1})
+	assert.Equal(t, a.Extra, [][]uint64{{7, 9}, {3}})
+
+	// merging in the other order gives the same result
+	c := NewAppliedIndex(0)
+	c.Merge(b)
+	c.Merge(&AppliedIndex{
+		UpTo:  []uint64{2, 0},
+		Extra: [][]uint64{{5, 9}, nil},
+	})
+	assert.Equal(t, a, c)
+}
+
+func TestAppliedIndexClone(t *testing.T) {
+	a := NewAppliedIndex(2)
+	a.UpTo[1] = 3
+	a.Extra[0] = []uint64{2}
+
+	c := a.Clone()
+	assert.Equal(t, a, c)
+	c.Extra[0][0] = 4
+	assert.Equal(t, a.Extra[0][0], uint64(2))
+}
+
+func TestConflictKeyConflictsWith(t *testing.T) {
+	ra := ConflictKey{Key: "a", Mode: ReadAccess}
+	wa := ConflictKey{Key: "a", Mode: WriteAccess}
+	wb := ConflictKey{Key: "b", Mode: WriteAccess}
+
+	assert.False(t, ra.ConflictsWith(ra))
+	assert.True(t, ra.ConflictsWith(wa))
+	assert.True(t, wa.ConflictsWith(ra))
+	assert.True(t, wa.ConflictsWith(wa))
+	assert.False(t, wa.ConflictsWith(wb))
+}
+
+func TestCommandError(t *testing.T) {
+	err := &CommandError{Results: []interface{}{"ok", ErrStateMachineExecution}}
+	assert.Equal(t, err.Error(), "Command execution error: "+ErrStateMachineExecution.Error())
+	err = &CommandError{}
+	assert.Equal(t, err.Error(), "Command execution error")
+}
@@ -0,0 +1,70 @@
+package test
+
+import (
+	"strings"
+
+	"github.com/go-distributed/epaxos"
+	"github.com/go-distributed/epaxos/message"
+)
+
+// DummyKVSM is a keyed state machine.
+// Its commands are "get <key>" and "put <key> <value>".
+type DummyKVSM struct {
+	DummySM
+}
+
+func NewDummyKVSM() *DummyKVSM {
+	return &DummyKVSM{
+		DummySM: DummySM{
+			ExecutionLog: make([]string, 0),
+		},
+	}
+}
+
+func (d *DummyKVSM) ConflictKeys(c message.Command) []epaxos.ConflictKey {
+	fields := strings.Fields(string(c))
+	if len(fields) < 2 {
+		return nil
+	}
+	mode := epaxos.WriteAccess
+	if fields[0] == "get" {
+		mode = epaxos.ReadAccess
+	}
+	return []epaxos.ConflictKey{{Key: fields[1], Mode: mode}}
+}
+
+func (d *DummyKVSM) HaveConflicts(c1 []message.Command, c2 []message.Command) bool {
+	for i := range c1 {
+		for j := range c2 {
+			for _, k1 := range d.ConflictKeys(c1[i]) {
+				for _, k2 := range d.ConflictKeys(c2[j]) {
+					if k1.ConflictsWith(k2) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// DummyOwnedKVSM is a DummyKVSM whose keys are owned by the
+// replica of their first digit, e.g. key "1a" is owned by replica 1.
+type DummyOwnedKVSM struct {
+	DummyKVSM
+	size uint8
+}
+
+func NewDummyOwnedKVSM(size uint8) *DummyOwnedKVSM {
+	return &DummyOwnedKVSM{
+		DummyKVSM: *NewDummyKVSM(),
+		size:      size,
+	}
+}
+
+func (d *DummyOwnedKVSM) Owner(key string) uint8 {
+	if key == "" {
+		return 0
+	}
+	return (key[0] - '0') % d.size
+}
@@ -2,11 +2,15 @@ package test
 
 import (
 	"bytes"
+	"errors"
+	"sync"
 
 	"github.com/go-distributed/epaxos"
 	"github.com/go-distributed/epaxos/message"
 )
 
+var ErrInvalidCommand = errors.New("Invalid command")
+
 type DummySM struct {
 	ExecutionLog []string
 }
@@ -19,13 +23,22 @@ func NewDummySM() *DummySM {
 
 func (d *DummySM) Execute(c []message.Command) ([]interface{}, error) {
 	result := make([]interface{}, 0)
+	failed := false
 	for i := range c {
 		if bytes.Compare(c[i], message.Command("error")) == 0 {
 			return nil, epaxos.ErrStateMachineExecution
 		}
+		if bytes.Compare(c[i], message.Command("invalid")) == 0 {
+			result = append(result, ErrInvalidCommand)
+			failed = true
+			continue
+		}
 		result = append(result, string(c[i]))
 		d.ExecutionLog = append(d.ExecutionLog, string(c[i]))
 	}
+	if failed {
+		return nil, &epaxos.CommandError{Results: result}
+	}
 	return result, nil
 }
 
@@ -39,3 +52,57 @@ func (d *DummySM) HaveConflicts(c1 []message.Command, c2 []message.Command) bool
 	}
 	return false
 }
+
+// DummyCheckpointSM is a DummySM that records the applied index
+// like a checkpointed state machine.
+type DummyCheckpointSM struct {
+	DummySM
+	Applied *epaxos.AppliedIndex
+}
+
+func NewDummyCheckpointSM() *DummyCheckpointSM {
+	return &DummyCheckpointSM{
+		DummySM: DummySM{
+			ExecutionLog: make([]string, 0),
+		},
+	}
+}
+
+func (d *DummyCheckpointSM) ExecuteApplied(c []message.Command, applied *epaxos.AppliedIndex) ([]interface{}, error) {
+	result, err := d.Execute(c)
+	if _, ok := err.(*epaxos.CommandError); err != nil && !ok {
+		return nil, err
+	}
+	// a batch failed deterministically is applied too
+	if d.Applied == nil {
+		d.Applied = epaxos.NewAppliedIndex(len(applied.UpTo))
+	}
+	d.Applied.Merge(applied)
+	return result, err
+}
+
+func (d *DummyCheckpointSM) AppliedIndex() *epaxos.AppliedIndex {
+	return d.Applied
+}
+
+// DummyConcurrentSM is a DummySM that can be executed concurrently.
+type DummyConcurrentSM struct {
+	DummySM
+	mu sync.Mutex
+}
+
+func NewDummyConcurrentSM() *DummyConcurrentSM {
+	return &DummyConcurrentSM{
+		DummySM: DummySM{
+			ExecutionLog: make([]string, 0),
+		},
+	}
+}
+
+func (d *DummyConcurrentSM) Execute(c []message.Command) ([]interface{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.DummySM.Execute(c)
+}
+
+func (d *DummyConcurrentSM) ConcurrentExecution() {}
@@ -2,6 +2,7 @@ package transporter
 
 import (
 	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/metrics"
 )
 
 type DummyTransporter struct {
@@ -9,6 +10,8 @@ type DummyTransporter struct {
 	Self       uint8
 	FastQuorum uint8
 	All        uint8
+
+	sentMessages metrics.Counter
 }
 
 func NewDummyTR(self uint8, size int) *DummyTransporter {
@@ -23,6 +26,7 @@ func NewDummyTR(self uint8, size int) *DummyTransporter {
 
 // non-block
 func (tr *DummyTransporter) Send(to uint8, msg message.Message) {
+	tr.sentMessages.Inc()
 	go func() {
 		tr.Chs[to] <- msg
 	}()
@@ -60,3 +64,11 @@ func (tr *DummyTransporter) RegisterChannels(chs []chan message.Message) {
 		tr.Chs[i] = chs[i]
 	}
 }
+
+// RegisterMetrics exposes the sent messages, there are no bytes
+// since the messages are not encoded.
+func (tr *DummyTransporter) RegisterMetrics(reg *metrics.Registry) {
+	reg.CounterFunc("epaxos_transport_messages_total",
+		"Messages sent and received by the transporter.",
+		metrics.Labels{"direction": "sent"}, tr.sentMessages.Value)
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 
 	"github.com/go-distributed/epaxos/message"
+	"github.com/go-distributed/epaxos/metrics"
 	"github.com/golang/glog"
 )
 
@@ -25,6 +26,11 @@ type UDPTransporter struct {
 	decBuffer *bytes.Buffer
 	enc       *gob.Encoder
 	dec       *gob.Decoder
+
+	sentMessages     metrics.Counter
+	sentBytes        metrics.Counter
+	receivedMessages metrics.Counter
+	receivedBytes    metrics.Counter
 }
 
 func NewUDPTransporter(addrStrs []string,
@@ -43,6 +49,9 @@ func NewUDPTransporter(addrStrs []string,
 	gob.Register(&message.Commit{})
 	gob.Register(&message.Prepare{})
 	gob.Register(&message.PrepareReply{})
+	gob.Register(&message.Digest{})
+	gob.Register(&message.Forward{})
+	gob.Register(&message.ForwardReply{})
 
 	for i := range addrs {
 		addrs[i], err = net.ResolveUDPAddr("udp", addrStrs[i])
@@ -82,10 +91,13 @@ func (nt *UDPTransporter) Send(to uint8, msg message.Message) {
 		if err := enc.Encode(&msg); err != nil {
 			glog.Warning("Encoding error ", err)
 		}
-		_, err := nt.Conns[to].Write(buf.Bytes())
+		n, err := nt.Conns[to].Write(buf.Bytes())
 		if err != nil {
 			glog.Warning("UDP write error ", err)
+			return
 		}
+		nt.sentMessages.Inc()
+		nt.sentBytes.Add(uint64(n))
 	}()
 }
 
@@ -148,6 +160,8 @@ func (nt *UDPTransporter) Start() error {
 				glog.Warning("UDP read error ", err)
 				continue
 			}
+			nt.receivedMessages.Inc()
+			nt.receivedBytes.Add(uint64(n))
 
 			buf.Reset()
 			buf.Write(b[:n])
@@ -169,3 +183,19 @@ func (nt *UDPTransporter) Stop() {
 		conn.Close()
 	}
 }
+
+// RegisterMetrics exposes the sent and received messages and bytes.
+func (nt *UDPTransporter) RegisterMetrics(reg *metrics.Registry) {
+	reg.CounterFunc("epaxos_transport_messages_total",
+		"Messages sent and received by the transporter.",
+		metrics.Labels{"direction": "sent"}, nt.sentMessages.Value)
+	reg.CounterFunc("epaxos_transport_messages_total",
+		"Messages sent and received by the transporter.",
+		metrics.Labels{"direction": "received"}, nt.receivedMessages.Value)
+	reg.CounterFunc("epaxos_transport_bytes_total",
+		"Bytes sent and received by the transporter.",
+		metrics.Labels{"direction": "sent"}, nt.sentBytes.Value)
+	reg.CounterFunc("epaxos_transport_bytes_total",
+		"Bytes sent and received by the transporter.",
+		metrics.Labels{"direction": "received"}, nt.receivedBytes.Value)
+}